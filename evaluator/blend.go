@@ -0,0 +1,118 @@
+package evaluator
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// BlendMode decides how Paper, lines and dots combine with the pixels that
+// are already on the paper.
+type BlendMode int
+
+const (
+	BlendNormal BlendMode = iota
+	BlendMultiply
+	BlendScreen
+	BlendAdd
+	BlendDifference
+)
+
+var blendModeNames = []string{"normal", "multiply", "screen", "add", "difference"}
+
+func (m BlendMode) String() string {
+	if m < 0 || int(m) >= len(blendModeNames) {
+		return fmt.Sprintf("BlendMode(%d)", int(m))
+	}
+	return blendModeNames[m]
+}
+
+// ParseBlendMode reads a mode name in any case, so that both `Blend multiply`
+// and `Blend Multiply` work.
+func ParseBlendMode(s string) (BlendMode, error) {
+	for i, name := range blendModeNames {
+		if strings.EqualFold(name, s) {
+			return BlendMode(i), nil
+		}
+	}
+	return BlendNormal, fmt.Errorf("unknown blend mode: %s", s)
+}
+
+func (m BlendMode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *BlendMode) UnmarshalText(text []byte) error {
+	mode, err := ParseBlendMode(string(text))
+	if err != nil {
+		return err
+	}
+	*m = mode
+	return nil
+}
+
+// blend combines one destination and source channel, both from 0 to 1.
+func (m BlendMode) blend(d float64, s float64) float64 {
+	switch m {
+	case BlendMultiply:
+		return d * s
+	case BlendScreen:
+		return 1 - (1-d)*(1-s)
+	case BlendAdd:
+		return math.Min(1, d+s)
+	case BlendDifference:
+		return math.Abs(d - s)
+	}
+	return s
+}
+
+// composite blends src onto dst and then mixes the result with dst by the
+// opacity of src.
+func composite(dst color.Color, src color.NRGBA, mode BlendMode) color.RGBA {
+	dr, dg, db, da := dst.RGBA()
+	if da == 0 {
+		return color.RGBA{
+			uint8(int(src.R) * int(src.A) / 255),
+			uint8(int(src.G) * int(src.A) / 255),
+			uint8(int(src.B) * int(src.A) / 255),
+			src.A,
+		}
+	}
+
+	a := float64(src.A) / 255
+	dAlpha := float64(da) / 65535
+	channel := func(d uint32, s uint8) uint8 {
+		dv := float64(d) / float64(da)
+		sv := float64(s) / 255
+		v := dv*(1-a) + mode.blend(dv, sv)*a
+		outAlpha := a + dAlpha*(1-a)
+		return uint8(math.Round(v * outAlpha * 255))
+	}
+	return color.RGBA{
+		channel(dr, src.R),
+		channel(dg, src.G),
+		channel(db, src.B),
+		uint8(math.Round((a + dAlpha*(1-a)) * 255)),
+	}
+}
+
+// blendImage wraps the paper so that drawing composites onto it instead of
+// replacing pixels.
+type blendImage struct {
+	*image.RGBA
+	mode BlendMode
+}
+
+func (b blendImage) Set(x int, y int, c color.Color) {
+	if !(image.Point{x, y}.In(b.Rect)) {
+		return
+	}
+	src := color.NRGBAModel.Convert(c).(color.NRGBA)
+	if b.mode == BlendNormal && src.A == 255 {
+		b.RGBA.SetRGBA(x, y, color.RGBA{src.R, src.G, src.B, 255})
+		return
+	}
+	b.RGBA.SetRGBA(x, y, composite(b.RGBA.RGBAAt(x, y), src, b.mode))
+}
@@ -0,0 +1,82 @@
+package evaluator
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/StephaneBunel/bresenham"
+	"golang.org/x/image/draw"
+)
+
+// Style is how a Canvas draws Paper, a line or a dot.
+type Style struct {
+	Color     color.Color
+	Width     int
+	Antialias bool
+	Blend     BlendMode
+}
+
+// Canvas is the paper the evaluator draws on. Points are in image
+// coordinates, where y grows downwards, and may fall outside the paper.
+// A Canvas that is also an image.Image is what Eval returns and what GIF
+// frames are made of.
+type Canvas interface {
+	// Clear covers the whole paper, as Paper does.
+	Clear(style Style)
+	Line(x1 int, y1 int, x2 int, y2 int, style Style)
+	Dot(x int, y int, style Style)
+	// Sample reads a pixel back for `Set A [x y]`.
+	Sample(x int, y int) color.Color
+}
+
+// RasterCanvas is the default Canvas, which draws pixels into an RGBA
+// image.
+type RasterCanvas struct {
+	*image.RGBA
+}
+
+func NewRasterCanvas(bounds image.Rectangle) Canvas {
+	return &RasterCanvas{image.NewRGBA(bounds)}
+}
+
+func (c *RasterCanvas) Clear(style Style) {
+	if _, _, _, a := style.Color.RGBA(); style.Blend == BlendNormal && a == 0xffff {
+		draw.Draw(c.RGBA, c.Rect, &image.Uniform{style.Color}, image.Point{0, 0}, draw.Src)
+		return
+	}
+	img := c.blend(style)
+	for y := c.Rect.Min.Y; y < c.Rect.Max.Y; y++ {
+		for x := c.Rect.Min.X; x < c.Rect.Max.X; x++ {
+			img.Set(x, y, style.Color)
+		}
+	}
+}
+
+func (c *RasterCanvas) Line(x1 int, y1 int, x2 int, y2 int, style Style) {
+	switch {
+	case style.Width > 1:
+		drawThickLine(c.blend(style), x1, y1, x2, y2, style.Width, style.Color, style.Antialias)
+	case style.Antialias:
+		drawWuLine(c.blend(style), x1, y1, x2, y2, style.Color)
+	default:
+		bresenham.DrawLine(c.blend(style), x1, y1, x2, y2, style.Color)
+	}
+}
+
+func (c *RasterCanvas) Dot(x int, y int, style Style) {
+	if style.Width > 1 {
+		drawThickLine(c.blend(style), x, y, x, y, style.Width, style.Color, style.Antialias)
+		return
+	}
+	c.blend(style).Set(x, y, style.Color)
+}
+
+func (c *RasterCanvas) Sample(x int, y int) color.Color {
+	return c.At(x, y)
+}
+
+// blend is the image as seen by drawing, which composites with the Blend
+// mode and the opacity of the color.
+func (c *RasterCanvas) blend(style Style) draw.Image {
+	return blendImage{RGBA: c.RGBA, mode: style.Blend}
+}
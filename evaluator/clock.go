@@ -0,0 +1,26 @@
+package evaluator
+
+import "time"
+
+// Clock supplies the time read by <Time 1> to <Time 4> for a frame.
+type Clock interface {
+	Now(frame int) time.Time
+}
+
+// WallClock reads the current time whatever the frame is.
+type WallClock struct{}
+
+func (c WallClock) Now(frame int) time.Time {
+	return time.Now()
+}
+
+// FixedClock starts at Start and advances by Step on every frame, so that
+// renders are reproducible.
+type FixedClock struct {
+	Start time.Time
+	Step  time.Duration
+}
+
+func (c FixedClock) Now(frame int) time.Time {
+	return c.Start.Add(time.Duration(frame) * c.Step)
+}
@@ -0,0 +1,125 @@
+package evaluator
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+)
+
+// ColorMode decides how three component colors such as `Pen 100 0 0` are
+// read. A single value is always a DBN gray level.
+type ColorMode int
+
+const (
+	ColorGrayscale ColorMode = iota
+	ColorRGB
+	ColorHSB
+)
+
+func (m ColorMode) String() string {
+	switch m {
+	case ColorGrayscale:
+		return "grayscale"
+	case ColorRGB:
+		return "rgb"
+	case ColorHSB:
+		return "hsb"
+	}
+	return fmt.Sprintf("ColorMode(%d)", int(m))
+}
+
+func ParseColorMode(s string) (ColorMode, error) {
+	for _, m := range []ColorMode{ColorGrayscale, ColorRGB, ColorHSB} {
+		if m.String() == s {
+			return m, nil
+		}
+	}
+	return ColorGrayscale, fmt.Errorf("unknown color mode: %s", s)
+}
+
+// componentsToColor converts three 0 to 100 components to a color. Hue runs
+// once around the color wheel from 0 to 100.
+func componentsToColor(mode ColorMode, a int, b int, c int) color.RGBA {
+	if mode == ColorHSB {
+		r, g, bl := hsbToRGB(float64(clampPercent(a))/100, float64(clampPercent(b))/100, float64(clampPercent(c))/100)
+		return color.RGBA{r, g, bl, 255}
+	}
+	return color.RGBA{percentToByte(a), percentToByte(b), percentToByte(c), 255}
+}
+
+// colorToComponents is the inverse of componentsToColor, for reading
+// channels back with `Set A [x y channel]`.
+func colorToComponents(mode ColorMode, col color.Color) [3]int {
+	r, g, b, _ := col.RGBA()
+	if mode == ColorHSB {
+		h, s, v := rgbToHSB(float64(r)/65535, float64(g)/65535, float64(b)/65535)
+		return [3]int{int(math.Round(h * 100)), int(math.Round(s * 100)), int(math.Round(v * 100))}
+	}
+	return [3]int{int(r * 100 / 65535), int(g * 100 / 65535), int(b * 100 / 65535)}
+}
+
+func clampPercent(n int) int {
+	if n < 0 {
+		return 0
+	}
+	if n > 100 {
+		return 100
+	}
+	return n
+}
+
+func percentToByte(n int) uint8 {
+	return uint8(clampPercent(n) * 255 / 100)
+}
+
+func hsbToRGB(h float64, s float64, v float64) (uint8, uint8, uint8) {
+	h = math.Mod(h, 1) * 6
+	i := math.Floor(h)
+	f := h - i
+	p := v * (1 - s)
+	q := v * (1 - s*f)
+	t := v * (1 - s*(1-f))
+
+	var r, g, b float64
+	switch int(i) {
+	case 0:
+		r, g, b = v, t, p
+	case 1:
+		r, g, b = q, v, p
+	case 2:
+		r, g, b = p, v, t
+	case 3:
+		r, g, b = p, q, v
+	case 4:
+		r, g, b = t, p, v
+	default:
+		r, g, b = v, p, q
+	}
+	return uint8(math.Round(r * 255)), uint8(math.Round(g * 255)), uint8(math.Round(b * 255))
+}
+
+func rgbToHSB(r float64, g float64, b float64) (float64, float64, float64) {
+	max := math.Max(r, math.Max(g, b))
+	min := math.Min(r, math.Min(g, b))
+	delta := max - min
+
+	var h, s float64
+	if max > 0 {
+		s = delta / max
+	}
+	if delta > 0 {
+		switch max {
+		case r:
+			h = math.Mod((g-b)/delta, 6)
+		case g:
+			h = (b-r)/delta + 2
+		default:
+			h = (r-g)/delta + 4
+		}
+		h /= 6
+		if h < 0 {
+			h++
+		}
+	}
+	return h, s, max
+}
@@ -0,0 +1,154 @@
+package evaluator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"text/scanner"
+)
+
+// OperationKind is the statement that drew an Operation.
+type OperationKind int
+
+const (
+	OperationPaper OperationKind = iota
+	OperationLine
+	OperationDot
+)
+
+var operationKindNames = []string{"paper", "line", "dot"}
+
+func (k OperationKind) String() string {
+	if k < 0 || int(k) >= len(operationKindNames) {
+		return fmt.Sprintf("OperationKind(%d)", int(k))
+	}
+	return operationKindNames[k]
+}
+
+func (k OperationKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+func (k *OperationKind) UnmarshalText(text []byte) error {
+	for i, name := range operationKindNames {
+		if name == string(text) {
+			*k = OperationKind(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown operation kind: %s", text)
+}
+
+// Operation is one Paper, Line or dot as it was drawn. Points are in image
+// coordinates, where y grows downwards. A dot starts and ends at the same
+// point and Paper has no points. Pos and End are the source range of the
+// statement that drew it. Lines drawn by the built-in libraries, such as the
+// sides of a rectangle, point at the call instead.
+type Operation struct {
+	Kind      OperationKind
+	X1        int
+	Y1        int
+	X2        int
+	Y2        int
+	Color     color.NRGBA
+	Width     int
+	Antialias bool
+	Blend     BlendMode
+	Pos       scanner.Position
+	End       scanner.Position
+}
+
+// Drawing records what an Eval drew, in order, so that it can be written as
+// vector output instead of pixels, saved as JSON or replayed.
+type Drawing struct {
+	Width      int
+	Height     int
+	Operations []Operation
+}
+
+// visible drops the operations that are hidden under the last opaque Paper,
+// which otherwise pile up in Forever programs.
+func (d *Drawing) visible() []Operation {
+	for i := len(d.Operations) - 1; i >= 0; i-- {
+		op := d.Operations[i]
+		if op.Kind == OperationPaper && op.Blend == BlendNormal && op.Color.A == 255 {
+			return d.Operations[i:]
+		}
+	}
+	return d.Operations
+}
+
+// Replay draws every operation onto c in order. Replaying onto a
+// RasterCanvas gives the same pixels as the Eval that recorded d.
+func (d *Drawing) Replay(c Canvas) {
+	for _, op := range d.Operations {
+		style := Style{Color: op.Color, Width: op.Width, Antialias: op.Antialias, Blend: op.Blend}
+		switch op.Kind {
+		case OperationPaper:
+			c.Clear(style)
+		case OperationLine:
+			c.Line(op.X1, op.Y1, op.X2, op.Y2, style)
+		case OperationDot:
+			c.Dot(op.X1, op.Y1, style)
+		}
+	}
+}
+
+// checkDrawing reports a drawing that the encoders cannot write.
+func checkDrawing(d *Drawing) error {
+	if d == nil {
+		return errors.New("no drawing: set WithDrawing before Eval")
+	}
+	if d.Width < 1 || d.Height < 1 {
+		return fmt.Errorf("invalid drawing size: %dx%d", d.Width, d.Height)
+	}
+	return nil
+}
+
+// EncodeJSON writes d as JSON, with kinds and Blend modes by name.
+func EncodeJSON(w io.Writer, d *Drawing) error {
+	return json.NewEncoder(w).Encode(d)
+}
+
+// DecodeJSON reads a drawing written by EncodeJSON.
+func DecodeJSON(r io.Reader) (*Drawing, error) {
+	d := &Drawing{}
+	if err := json.NewDecoder(r).Decode(d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// resetDrawing starts an empty drawing the size of the paper.
+func (e *Evaluator) resetDrawing() {
+	if !e.WithDrawing {
+		e.Drawing = nil
+		return
+	}
+	e.Drawing = &Drawing{Width: e.bounds.Dx(), Height: e.bounds.Dy()}
+}
+
+func (e *Evaluator) record(kind OperationKind, x1 int, y1 int, x2 int, y2 int, style Style) {
+	if e.Drawing == nil {
+		return
+	}
+	var pos, end scanner.Position
+	if e.source != nil {
+		pos, end = e.source.StartPos(), e.source.EndPos()
+	}
+	e.Drawing.Operations = append(e.Drawing.Operations, Operation{
+		Kind:      kind,
+		X1:        x1,
+		Y1:        y1,
+		X2:        x2,
+		Y2:        y2,
+		Color:     color.NRGBAModel.Convert(style.Color).(color.NRGBA),
+		Width:     style.Width,
+		Antialias: style.Antialias,
+		Blend:     style.Blend,
+		Pos:       pos,
+		End:       end,
+	})
+}
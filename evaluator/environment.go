@@ -1,10 +1,15 @@
 package evaluator
 
+const ARRAY_LENGTH = 1000
+
 type Value interface{}
 
 type Environment struct {
-	store map[string]Value
-	outer *Environment
+	store  map[string]Value
+	outer  *Environment
+	array  []int
+	number bool
+	result *int
 }
 
 func NewEnvironment() *Environment {
@@ -18,6 +23,32 @@ func NewEnclosedEnvironment(outer *Environment) *Environment {
 	return env
 }
 
+// NewNumberEnvironment encloses outer for a call to a Number, whose body can
+// end early with a Value statement.
+func NewNumberEnvironment(outer *Environment) *Environment {
+	env := NewEnclosedEnvironment(outer)
+	env.number = true
+	return env
+}
+
+func (e *Environment) InNumber() bool {
+	return e.number
+}
+
+// Return records the result of the Number running in this environment.
+func (e *Environment) Return(val int) {
+	e.result = &val
+}
+
+// Returned reports the result once a Value statement has run, so that the
+// rest of the body, including enclosing blocks and loops, is skipped.
+func (e *Environment) Returned() (int, bool) {
+	if e.result == nil {
+		return 0, false
+	}
+	return *e.result, true
+}
+
 func (e *Environment) Get(name string) (Value, bool) {
 	obj, ok := e.store[name]
 	if !ok && e.outer != nil {
@@ -30,3 +61,32 @@ func (e *Environment) Set(name string, val Value) Value {
 	e.store[name] = val
 	return val
 }
+
+// GetArray and SetArray access the single Array shared by the outermost
+// environment. Indices start at 1 like the original DBN.
+func (e *Environment) GetArray(index int) (int, bool) {
+	if e.outer != nil {
+		return e.outer.GetArray(index)
+	}
+	if index < 1 || index > ARRAY_LENGTH {
+		return 0, false
+	}
+	if e.array == nil {
+		return 0, true
+	}
+	return e.array[index-1], true
+}
+
+func (e *Environment) SetArray(index int, val int) bool {
+	if e.outer != nil {
+		return e.outer.SetArray(index, val)
+	}
+	if index < 1 || index > ARRAY_LENGTH {
+		return false
+	}
+	if e.array == nil {
+		e.array = make([]int, ARRAY_LENGTH)
+	}
+	e.array[index-1] = val
+	return true
+}
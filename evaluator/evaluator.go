@@ -5,40 +5,93 @@ import (
 	"fmt"
 	"image"
 	"image/color"
+	"image/color/palette"
 	"image/gif"
 	"io"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
-	"github.com/StephaneBunel/bresenham"
 	"github.com/tnantoka/dbngo/parser"
 	"golang.org/x/image/draw"
 )
 
 const DEFAULT_LENGTH = 100
+const MAX_LENGTH = 4096
+const DEFAULT_FOREVER_FRAMES = 100
+const MAX_CALL_DEPTH = 1000
+
+const (
+	CodeNotFound        = "not-found"
+	CodeOutOfRange      = "out-of-range"
+	CodeInvalidArgument = "invalid-argument"
+	CodeArity           = "arity"
+	CodeLoad            = "load"
+	CodeNet             = "net"
+	CodeDivisionByZero  = "division-by-zero"
+	CodeKind            = "kind"
+	CodeCallDepth       = "call-depth"
+	CodeInternal        = "internal"
+)
+
+// abort unwinds the evaluation after an error that makes going on pointless.
+type abort struct{}
 
 //go:embed builtins/*
 var builtinsFS embed.FS
 
 type Evaluator struct {
-	length    int
-	Errors    []string
-	color     color.Color
-	img       *image.RGBA
-	GIF       *gif.GIF
-	Scale     int
-	Directory string
-	WithGIF   bool
-	MaxFrames int
+	Width         int
+	Height        int
+	Errors        []parser.Diagnostic
+	color         color.Color
+	NewCanvas     func(bounds image.Rectangle) Canvas
+	GIF           *gif.GIF
+	Scale         int
+	Directory     string
+	WithGIF       bool
+	Drawing       *Drawing
+	WithDrawing   bool
+	MaxFrames     int
+	ForeverFrames int
+	LegacyRepeat  bool
+	Faithful      bool
+	ColorMode     ColorMode
+	Antialias     bool
+	PenWidth      int
+	Mouse         MouseInput
+	Key           KeyInput
+	Clock         Clock
+	Net           NetProvider
+	frame         int
+	forever       bool
+	depth         int
+	blend         BlendMode
+	antialias     bool
+	penWidth      int
+	canvas        Canvas
+	source        parser.Statement
+	bounds        image.Rectangle
+	statement     parser.Statement
 }
 
 func New() *Evaluator {
-	return &Evaluator{length: DEFAULT_LENGTH, color: color.RGBA{0, 0, 0, 255}, Scale: 1, Directory: "", WithGIF: false, MaxFrames: 0}
+	return &Evaluator{Width: DEFAULT_LENGTH, Height: DEFAULT_LENGTH, color: color.RGBA{0, 0, 0, 255}, Scale: 1, Directory: "", WithGIF: false, MaxFrames: 0, ForeverFrames: DEFAULT_FOREVER_FRAMES, PenWidth: 1, NewCanvas: NewRasterCanvas, Clock: WallClock{}, Net: NewMemoryNet()}
 }
 
-func (e *Evaluator) Eval(input io.Reader, path string) image.Image {
-	e.img = image.NewRGBA(image.Rect(0, 0, e.length, e.length))
+func (e *Evaluator) Eval(input io.Reader, path string) (img image.Image) {
+	e.newCanvas(e.canvasRect(e.Width, e.Height))
 	e.GIF = &gif.GIF{}
+	e.resetDrawing()
+	e.frame = 0
+	e.forever = false
+	e.depth = 0
+	e.blend = BlendNormal
+	e.antialias = e.Antialias
+	e.penWidth = e.PenWidth
+	e.statement = nil
+	e.source = nil
 
 	l := new(parser.Lexer)
 	l.Filename = path
@@ -48,39 +101,88 @@ func (e *Evaluator) Eval(input io.Reader, path string) image.Image {
 	e.Errors = l.Errors
 
 	if len(e.Errors) > 0 {
-		return e.img
+		return e.image()
 	}
 
 	env := NewEnvironment()
 	e.loadBuiltins(env)
 
-	draw.Draw(e.img, e.img.Bounds(), &image.Uniform{color.RGBA{255, 255, 255, 255}}, image.Point{0, 0}, draw.Src)
+	e.clearPaper()
 	e.addGIFFrame()
 
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(abort); !ok {
+				e.addInternalError(r)
+			}
+			img = e.scale()
+		}
+	}()
+
 	e.evalStatements(l.Statements, env)
 
 	return e.scale()
 }
 
+// newCanvas starts a canvas for a paper of the given bounds, falling back to
+// a RasterCanvas when NewCanvas is not set.
+func (e *Evaluator) newCanvas(bounds image.Rectangle) {
+	if e.NewCanvas == nil {
+		e.NewCanvas = NewRasterCanvas
+	}
+	e.bounds = bounds
+	e.canvas = e.NewCanvas(bounds)
+}
+
+// image is the canvas as an image, or nil when the canvas is not one.
+func (e *Evaluator) image() image.Image {
+	if img, ok := e.canvas.(image.Image); ok {
+		return img
+	}
+	return nil
+}
+
 func (e *Evaluator) scale() image.Image {
-	if e.Scale < 2 {
-		return e.img
+	img := e.image()
+	if img == nil || e.Scale < 2 {
+		return img
 	}
 
-	scaled := image.NewRGBA(image.Rect(0, 0, e.length*e.Scale, e.length*e.Scale))
-	draw.CatmullRom.Scale(scaled, scaled.Bounds(), e.img, e.img.Bounds(), draw.Over, nil)
+	bounds := img.Bounds()
+	scaled := image.NewRGBA(image.Rect(0, 0, bounds.Dx()*e.Scale, bounds.Dy()*e.Scale))
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), img, bounds, draw.Over, nil)
 
 	return scaled
 }
 
 func (e *Evaluator) evalStatements(statements []parser.Statement, env *Environment) {
 	for _, statement := range statements {
+		if _, ok := env.Returned(); ok {
+			return
+		}
 		e.evalStatement(statement, env)
 	}
 }
 
 func (e *Evaluator) evalStatement(statement parser.Statement, env *Environment) {
+	// Not deferred, so a recovered panic still sees the innermost statement.
+	outer, outerSource := e.statement, e.source
+	if statement != nil {
+		e.statement = statement
+		if !isBuiltin(statement.StartPos().Filename) {
+			e.source = statement
+		}
+	}
+
 	switch s := statement.(type) {
+	case *parser.SizeStatement:
+		e.evalSizeStatement(s, env)
+	case *parser.BlendStatement:
+		e.evalBlendStatement(s, env)
+	case *parser.AntialiasStatement:
+		e.evalAntialiasStatement(s, env)
+	case *parser.WidthStatement:
+		e.evalWidthStatement(s, env)
 	case *parser.PaperStatement:
 		e.evalPaperStatement(s, env)
 	case *parser.PenStatement:
@@ -89,6 +191,10 @@ func (e *Evaluator) evalStatement(statement parser.Statement, env *Environment)
 		e.evalLineStatement(s, env)
 	case *parser.SetStatement:
 		e.evalSetStatement(s, env)
+	case *parser.ArrayStatement:
+		e.evalArrayStatement(s, env)
+	case *parser.NetStatement:
+		e.evalNetStatement(s, env)
 	case *parser.DotStatement:
 		e.evalDotStatement(s, env)
 	case *parser.CopyStatement:
@@ -97,6 +203,8 @@ func (e *Evaluator) evalStatement(statement parser.Statement, env *Environment)
 		e.evalStatements(s.Statements, env)
 	case *parser.RepeatStatement:
 		e.evalRepeatStatement(s, env)
+	case *parser.ForeverStatement:
+		e.evalForeverStatement(s, env)
 	case *parser.SameStatement:
 		e.evalSameStatement(s, env)
 	case *parser.NotSameStatement:
@@ -113,24 +221,91 @@ func (e *Evaluator) evalStatement(statement parser.Statement, env *Environment)
 		e.evalLoadStatement(s, env)
 	case *parser.DefineNumberStatement:
 		e.evalDefineNumberStatement(s, env)
+	case *parser.ValueStatement:
+		e.evalValueStatement(s, env)
+	}
+
+	e.statement, e.source = outer, outerSource
+}
+
+// evalSizeStatement starts over on a blank canvas of the given size. Earlier
+// GIF frames are dropped because every frame must have the same size.
+func (e *Evaluator) evalSizeStatement(statement *parser.SizeStatement, env *Environment) {
+	width := e.evalNumber(statement.Width, env)
+	height := e.evalNumber(statement.Height, env)
+	if width < 1 || height < 1 || width > MAX_LENGTH || height > MAX_LENGTH {
+		e.addError(statement.Token, CodeInvalidArgument, "Invalid Size: %dx%d", width, height)
+		return
 	}
+	e.newCanvas(e.canvasRect(width, height))
+	e.GIF.Image = nil
+	e.GIF.Delay = nil
+	e.resetDrawing()
+	e.clearPaper()
+	e.addGIFFrame()
 }
 
 func (e *Evaluator) evalPaperStatement(statement *parser.PaperStatement, env *Environment) {
-	draw.Draw(e.img, e.img.Bounds(), &image.Uniform{e.evalColor(statement.Value, env)}, image.Point{0, 0}, draw.Src)
+	style := e.style(e.evalColor(statement.Value, env))
+	e.canvas.Clear(style)
+	e.record(OperationPaper, 0, 0, 0, 0, style)
 	e.addGIFFrame()
 }
 
+func (e *Evaluator) evalBlendStatement(statement *parser.BlendStatement, env *Environment) {
+	mode, err := ParseBlendMode(statement.Mode.Literal)
+	if err != nil {
+		e.addError(statement.Mode, CodeInvalidArgument, "Unknown Blend mode: %s", statement.Mode.Literal)
+		return
+	}
+	e.blend = mode
+}
+
+// evalAntialiasStatement switches lines between Bresenham, which keeps
+// pixel-exact DBN output, and Wu's antialiased rasterizer.
+func (e *Evaluator) evalAntialiasStatement(statement *parser.AntialiasStatement, env *Environment) {
+	switch value := statement.Value.Literal; {
+	case strings.EqualFold(value, "on"):
+		e.antialias = true
+	case strings.EqualFold(value, "off"):
+		e.antialias = false
+	default:
+		e.addError(statement.Value, CodeInvalidArgument, "Antialias expects on or off, got %s", statement.Value.Literal)
+	}
+}
+
+// evalWidthStatement sets the stroke width of lines and dots in pixels.
+func (e *Evaluator) evalWidthStatement(statement *parser.WidthStatement, env *Environment) {
+	width := e.evalNumber(statement.Value, env)
+	if width < 1 {
+		e.addError(statement.Token, CodeInvalidArgument, "Invalid Width: %d", width)
+		return
+	}
+	e.penWidth = width
+}
+
+// clearPaper starts a white paper, as Eval and Size do.
+func (e *Evaluator) clearPaper() {
+	style := Style{Color: color.RGBA{255, 255, 255, 255}, Width: 1}
+	e.canvas.Clear(style)
+	e.record(OperationPaper, 0, 0, 0, 0, style)
+}
+
+// style is how the current Width, Antialias and Blend draw col.
+func (e *Evaluator) style(col color.Color) Style {
+	return Style{Color: col, Width: e.penWidth, Antialias: e.antialias, Blend: e.blend}
+}
+
 func (e *Evaluator) evalPenStatement(statement *parser.PenStatement, env *Environment) {
 	e.color = e.evalColor(statement.Value, env)
 }
 
 func (e *Evaluator) evalLineStatement(statement *parser.LineStatement, env *Environment) {
-	x1 := e.evalNumber(statement.X1, env)
-	y1 := 100 - e.evalNumber(statement.Y1, env)
-	x2 := e.evalNumber(statement.X2, env)
-	y2 := 100 - e.evalNumber(statement.Y2, env)
-	bresenham.DrawLine(e.img, x1, y1, x2, y2, e.color)
+	x1, y1 := e.toImage(e.evalNumber(statement.X1, env), e.evalNumber(statement.Y1, env))
+	x2, y2 := e.toImage(e.evalNumber(statement.X2, env), e.evalNumber(statement.Y2, env))
+	style := e.style(e.color)
+	e.canvas.Line(x1, y1, x2, y2, style)
+	e.record(OperationLine, x1, y1, x2, y2, style)
 	e.addGIFFrame()
 }
 
@@ -138,25 +313,136 @@ func (e *Evaluator) evalSetStatement(statement *parser.SetStatement, env *Enviro
 	env.Set(statement.Name, e.evalNumber(statement.Value, env))
 }
 
+func (e *Evaluator) evalArrayStatement(statement *parser.ArrayStatement, env *Environment) {
+	index := e.evalNumber(statement.Index, env)
+	if !env.SetArray(index, e.evalNumber(statement.Value, env)) {
+		e.addError(statement.Token, CodeOutOfRange, "Array index out of range: %d", index)
+	}
+}
+
+func (e *Evaluator) evalNetStatement(statement *parser.NetStatement, env *Environment) {
+	slot := e.evalNumber(statement.Slot, env)
+	value := e.evalNumber(statement.Value, env)
+	if e.Net == nil {
+		return
+	}
+	if err := e.Net.Set(slot, value); err != nil {
+		e.addError(statement.Token, CodeNet, "%s", err.Error())
+	}
+}
+
+// evalDotStatement sets one pixel, which `Set A [x y]` reads back. Only the
+// dots of the built-in shapes, such as circle, are drawn Width wide.
 func (e *Evaluator) evalDotStatement(statement *parser.DotStatement, env *Environment) {
-	x := e.evalNumber(statement.X, env)
-	y := 100 - e.evalNumber(statement.Y, env)
-	e.img.Set(x, y, e.evalColor(statement.Value, env))
+	x, y := e.toImage(e.evalNumber(statement.X, env), e.evalNumber(statement.Y, env))
+	style := e.style(e.evalColor(statement.Value, env))
+	if !isBuiltin(statement.StartPos().Filename) {
+		style.Width = 1
+	}
+	e.canvas.Dot(x, y, style)
+	e.record(OperationDot, x, y, x, y, style)
 	e.addGIFFrame()
 }
 
 func (e *Evaluator) evalCopyStatement(statement *parser.CopyStatement, env *Environment) {
 	name := statement.Name
-	x := e.evalNumber(statement.X, env)
-	y := 100 - e.evalNumber(statement.Y, env)
-	r, _, _, _ := e.img.At(x, y).RGBA()
-	env.Set(name, int(100-r*100/65535))
+	x, y := e.toImage(e.evalNumber(statement.X, env), e.evalNumber(statement.Y, env))
+	col := e.canvas.Sample(x, y)
+	if statement.Channel == nil {
+		env.Set(name, e.grayLevel(col))
+		return
+	}
+	channel := e.evalNumber(statement.Channel, env)
+	if e.ColorMode == ColorGrayscale {
+		e.addNodeError(statement.Channel, CodeInvalidArgument, "Color channels need the rgb or hsb color mode")
+		return
+	}
+	if channel < 1 || channel > 3 {
+		e.addNodeError(statement.Channel, CodeInvalidArgument, "Invalid color channel: %d", channel)
+		return
+	}
+	env.Set(name, colorToComponents(e.ColorMode, col)[channel-1])
+}
+
+// grayLevel reads a pixel back as a DBN gray level, where 100 is black.
+func (e *Evaluator) grayLevel(col color.Color) int {
+	if e.ColorMode == ColorGrayscale {
+		r, _, _, _ := col.RGBA()
+		return int(100 - r*100/65535)
+	}
+	gray := color.GrayModel.Convert(col).(color.Gray)
+	return 100 - int(gray.Y)*100/255
+}
+
+// canvasRect is the image size for a width by height paper. In faithful mode
+// both 0 and width are on the paper, as on the original 101x101 DBN paper.
+func (e *Evaluator) canvasRect(width int, height int) image.Rectangle {
+	if e.Faithful {
+		return image.Rect(0, 0, width+1, height+1)
+	}
+	return image.Rect(0, 0, width, height)
+}
+
+// toImage converts DBN coordinates, whose y axis points up, to image
+// coordinates for the current canvas size.
+func (e *Evaluator) toImage(x int, y int) (int, int) {
+	bottom := e.bounds.Dy()
+	if e.Faithful {
+		bottom--
+	}
+	return x, bottom - y
 }
 
+// evalRepeatStatement evaluates both bounds once and counts from one to the
+// other in either direction, like the original DBN.
 func (e *Evaluator) evalRepeatStatement(statement *parser.RepeatStatement, env *Environment) {
+	if e.LegacyRepeat {
+		e.evalLegacyRepeatStatement(statement, env)
+		return
+	}
+
+	from := e.evalNumber(statement.From, env)
+	to := e.evalNumber(statement.To, env)
+	step := 1
+	if from > to {
+		step = -1
+	}
+	for i := from; ; i += step {
+		env.Set(statement.Name, i)
+		e.evalStatements(statement.Body.(*parser.BlockStatement).Statements, env)
+		if _, ok := env.Returned(); ok || i == to {
+			break
+		}
+	}
+}
+
+// evalLegacyRepeatStatement is the behaviour of earlier versions, which only
+// count up and evaluate To before every iteration.
+func (e *Evaluator) evalLegacyRepeatStatement(statement *parser.RepeatStatement, env *Environment) {
 	for i := e.evalNumber(statement.From, env); i <= e.evalNumber(statement.To, env); i++ {
 		env.Set(statement.Name, i)
 		e.evalStatements(statement.Body.(*parser.BlockStatement).Statements, env)
+		if _, ok := env.Returned(); ok {
+			break
+		}
+	}
+}
+
+// evalForeverStatement runs the body once per animation frame until
+// ForeverFrames iterations have run or the GIF holds MaxFrames frames.
+func (e *Evaluator) evalForeverStatement(statement *parser.ForeverStatement, env *Environment) {
+	forever := e.forever
+	e.forever = true
+	defer func() { e.forever = forever }()
+
+	errors := len(e.Errors)
+	for i := 0; i < e.ForeverFrames && !e.gifFull() && len(e.Errors) == errors; i++ {
+		e.evalStatements(statement.Body.(*parser.BlockStatement).Statements, env)
+		if _, ok := env.Returned(); ok {
+			break
+		}
+		e.frame++
+		e.appendGIFFrame()
 	}
 }
 
@@ -197,23 +483,28 @@ func (e *Evaluator) evalDefineCommandStatement(statement *parser.DefineCommandSt
 }
 
 func (e *Evaluator) evalCallCommandStatement(statement *parser.CallCommandStatement, env *Environment) {
-	fun, ok := env.Get(statement.Token.Literal)
+	parameters, body, ok := e.lookupCall(statement.Token, "Command", len(statement.Arguments), env)
 	if !ok {
-		e.Errors = append(e.Errors, fmt.Sprintf("%sCommand not found: %s", statement.Token.Pos(), statement.Token.Literal))
 		return
 	}
-	funStatement := fun.(*parser.DefineCommandStatement)
 	newEnv := NewEnclosedEnvironment(env)
 	for i, arg := range statement.Arguments {
-		newEnv.Set(funStatement.Parameters[i], e.evalNumber(arg, env))
+		newEnv.Set(parameters[i], e.evalNumber(arg, env))
 	}
-	e.evalStatements(funStatement.Body.(*parser.BlockStatement).Statements, newEnv)
+	e.enter(statement.Token)
+	defer e.leave()
+	e.evalStatements(body.Statements, newEnv)
 }
 
+// evalLoadStatement runs another file. Loads count as calls, so a file that
+// loads itself stops at the call depth limit.
 func (e *Evaluator) evalLoadStatement(statement *parser.LoadStatement, env *Environment) {
+	e.enter(statement.Token)
+	defer e.leave()
+
 	file, err := os.Open(e.Directory + "/" + statement.Token.Literal)
 	if err != nil {
-		e.Errors = append(e.Errors, fmt.Sprintf("%s%s", statement.Token.Pos(), err.Error()))
+		e.addError(statement.Token, CodeLoad, "%s", err.Error())
 		return
 	}
 
@@ -222,6 +513,8 @@ func (e *Evaluator) evalLoadStatement(statement *parser.LoadStatement, env *Envi
 	l.Init(file)
 
 	parser.Parse(l)
+	// Closed before running the file, so nested Loads hold one file at most.
+	file.Close()
 	e.Errors = append(e.Errors, l.Errors...)
 
 	if len(l.Errors) > 0 {
@@ -235,16 +528,52 @@ func (e *Evaluator) evalDefineNumberStatement(statement *parser.DefineNumberStat
 	env.Set(statement.Name, statement)
 }
 
+// evalValueStatement ends the Number being evaluated. Outside a Number body
+// Value has nothing to return to and is ignored.
+func (e *Evaluator) evalValueStatement(statement *parser.ValueStatement, env *Environment) {
+	if !env.InNumber() {
+		return
+	}
+	env.Return(e.evalNumber(statement.Result, env))
+}
+
 func (e *Evaluator) evalColor(expression parser.Expression, env *Environment) color.Color {
 	switch exp := expression.(type) {
-	case *parser.IntegerExpression, *parser.IdentifierExpression, *parser.CalculateExpression, *parser.CallNumberExpression:
-		num := e.evalNumber(exp, env)
-		col := uint8((100 - num) * 255 / 100)
-		return color.RGBA{col, col, col, 255}
+	case *parser.IntegerExpression, *parser.IdentifierExpression, *parser.CalculateExpression, *parser.CallNumberExpression, *parser.ArrayExpression, *parser.NetExpression:
+		return e.grayColor(e.evalNumber(exp, env))
+	case *parser.ColorExpression:
+		var col color.RGBA
+		if len(exp.Values) == 1 {
+			col = e.grayColor(e.evalNumber(exp.Values[0], env))
+		} else {
+			values := [3]int{}
+			for i, value := range exp.Values {
+				values[i] = e.evalNumber(value, env)
+			}
+			if e.ColorMode == ColorGrayscale {
+				e.addNodeError(exp, CodeInvalidArgument, "Colors need the rgb or hsb color mode: %s", exp.String())
+				return color.RGBA{0, 0, 0, 255}
+			}
+			col = componentsToColor(e.ColorMode, values[0], values[1], values[2])
+		}
+		if exp.Opacity == nil {
+			return col
+		}
+		opacity := clampPercent(e.evalNumber(exp.Opacity, env))
+		return color.NRGBA{col.R, col.G, col.B, uint8(opacity * 255 / 100)}
 	}
 	return color.RGBA{0, 0, 0, 0}
 }
 
+func (e *Evaluator) grayColor(num int) color.RGBA {
+	if e.Faithful {
+		// DBN clamps colors to the 0 to 100 range.
+		num = clampPercent(num)
+	}
+	col := uint8((100 - num) * 255 / 100)
+	return color.RGBA{col, col, col, 255}
+}
+
 func (e *Evaluator) evalNumber(expression parser.Expression, env *Environment) int {
 	switch exp := expression.(type) {
 	case *parser.IntegerExpression:
@@ -253,10 +582,16 @@ func (e *Evaluator) evalNumber(expression parser.Expression, env *Environment) i
 	case *parser.IdentifierExpression:
 		num, ok := env.Get(exp.Token.Literal)
 		if !ok || num == nil {
-			e.Errors = append(e.Errors, fmt.Sprintf("%sIdentifier not found: %s", exp.Token.Pos(), exp.Token.Literal))
+			e.addError(exp.Token, CodeNotFound, "Identifier not found: %s", exp.Token.Literal)
+			return 0
+		}
+		value, ok := num.(int)
+		if !ok {
+			kind, definition := describeDefinition(num)
+			e.addDefinitionError(exp.Token, definition, CodeKind, "%s is a %s, not a variable", exp.Token.Literal, kind)
 			return 0
 		}
-		return num.(int)
+		return value
 	case *parser.CalculateExpression:
 		left := e.evalNumber(exp.Left, env)
 		right := e.evalNumber(exp.Right, env)
@@ -268,74 +603,368 @@ func (e *Evaluator) evalNumber(expression parser.Expression, env *Environment) i
 		case "*":
 			return left * right
 		case "/":
+			if right == 0 {
+				e.addNodeError(exp, CodeDivisionByZero, "Division by zero: %s", exp.String())
+				return 0
+			}
 			return left / right
 		}
 	case *parser.CallNumberExpression:
-		fun, ok := env.Get(exp.Token.Literal)
+		if num, ok := e.evalBuiltinNumber(exp, env); ok {
+			return num
+		}
+		parameters, body, ok := e.lookupCall(exp.Token, "Number", len(exp.Arguments), env)
 		if !ok {
-			e.Errors = append(e.Errors, fmt.Sprintf("%sNumber not found: %s", exp.Token.Pos(), exp.Token.Literal))
 			return 0
 		}
-		funStatement := fun.(*parser.DefineNumberStatement)
-		newEnv := NewEnclosedEnvironment(env)
+		newEnv := NewNumberEnvironment(env)
 		for i, arg := range exp.Arguments {
-			newEnv.Set(funStatement.Parameters[i], e.evalNumber(arg, env))
+			newEnv.Set(parameters[i], e.evalNumber(arg, env))
 		}
-		for _, s := range funStatement.Body.(*parser.BlockStatement).Statements {
-			vs, ok := s.(*parser.ValueStatement)
-			if ok {
-				return e.evalNumber(vs.Result, newEnv)
-			} else {
-				e.evalStatement(s, newEnv)
-			}
+		e.enter(exp.Token)
+		defer e.leave()
+		e.evalStatements(body.Statements, newEnv)
+		num, _ := newEnv.Returned()
+		return num
+	case *parser.ArrayExpression:
+		index := e.evalNumber(exp.Index, env)
+		num, ok := env.GetArray(index)
+		if !ok {
+			e.addError(exp.Token, CodeOutOfRange, "Array index out of range: %d", index)
+			return 0
+		}
+		return num
+	case *parser.NetExpression:
+		slot := e.evalNumber(exp.Slot, env)
+		if e.Net == nil {
+			return 0
+		}
+		num, err := e.Net.Get(slot)
+		if err != nil {
+			e.addError(exp.Token, CodeNet, "%s", err.Error())
+			return 0
 		}
+		return num
+	}
+	return 0
+}
+
+// lookupCall finds the Command or Number called by token and checks that it
+// is the expected kind and gets one argument per parameter.
+func (e *Evaluator) lookupCall(token parser.Token, kind string, arguments int, env *Environment) ([]string, *parser.BlockStatement, bool) {
+	fun, ok := env.Get(token.Literal)
+	if !ok {
+		e.addError(token, CodeNotFound, "%s not found: %s", kind, token.Literal)
+		return nil, nil, false
+	}
+	actual, definition := describeDefinition(fun)
+	if actual != kind {
+		e.addDefinitionError(token, definition, CodeKind, "%s is a %s, not a %s", token.Literal, actual, kind)
+		return nil, nil, false
+	}
+	var parameters []string
+	var body parser.Statement
+	switch f := fun.(type) {
+	case *parser.DefineCommandStatement:
+		parameters, body = f.Parameters, f.Body
+	case *parser.DefineNumberStatement:
+		parameters, body = f.Parameters, f.Body
+	}
+	if arguments != len(parameters) {
+		e.addDefinitionError(token, definition, CodeArity, "%s expects %s, got %d", token.Literal, countArguments(len(parameters)), arguments)
+		return nil, nil, false
+	}
+	return parameters, body.(*parser.BlockStatement), true
+}
+
+// describeDefinition tells what kind of name holds value in an environment
+// and where it was defined, if that is known.
+func describeDefinition(value interface{}) (string, parser.Node) {
+	switch v := value.(type) {
+	case *parser.DefineCommandStatement:
+		return "Command", v
+	case *parser.DefineNumberStatement:
+		return "Number", v
+	}
+	return "variable", nil
+}
+
+func countArguments(n int) string {
+	if n == 1 {
+		return "1 argument"
+	}
+	return fmt.Sprintf("%d arguments", n)
+}
+
+// evalBuiltinNumber evaluates Mouse, Key and Time, unless the program has
+// defined the name itself.
+func (e *Evaluator) evalBuiltinNumber(expression *parser.CallNumberExpression, env *Environment) (int, bool) {
+	if _, defined := env.Get(expression.Token.Literal); defined {
+		return 0, false
+	}
+	switch expression.Token.Literal {
+	case "Mouse", "mouse":
+		return e.evalMouse(expression, env), true
+	case "Key", "key":
+		return e.evalKey(expression, env), true
+	case "Time", "time":
+		return e.evalTime(expression, env), true
+	}
+	return 0, false
+}
+
+func (e *Evaluator) evalMouse(expression *parser.CallNumberExpression, env *Environment) int {
+	n, ok := e.evalBuiltinArgument(expression, env)
+	if !ok {
+		return 0
+	}
+	x, y, button := 0, 0, 0
+	if e.Mouse != nil {
+		x, y, button = e.Mouse.Mouse(e.frame)
+	}
+	switch n {
+	case 1:
+		return x
+	case 2:
+		return y
+	case 3:
+		return button
+	}
+	e.addError(expression.Token, CodeInvalidArgument, "Invalid %s argument: %d", expression.Token.Literal, n)
+	return 0
+}
+
+func (e *Evaluator) evalKey(expression *parser.CallNumberExpression, env *Environment) int {
+	n, ok := e.evalBuiltinArgument(expression, env)
+	if !ok {
+		return 0
+	}
+	if n < 1 || n > KEY_COUNT {
+		e.addError(expression.Token, CodeInvalidArgument, "Invalid %s argument: %d", expression.Token.Literal, n)
+		return 0
+	}
+	if e.Key != nil && e.Key.Key(e.frame, n) {
+		return 100
 	}
 	return 0
 }
 
+func (e *Evaluator) evalTime(expression *parser.CallNumberExpression, env *Environment) int {
+	n, ok := e.evalBuiltinArgument(expression, env)
+	if !ok {
+		return 0
+	}
+	var clock Clock = WallClock{}
+	if e.Clock != nil {
+		clock = e.Clock
+	}
+	now := clock.Now(e.frame)
+	switch n {
+	case 1:
+		return now.Hour()
+	case 2:
+		return now.Minute()
+	case 3:
+		return now.Second()
+	case 4:
+		return now.Nanosecond() / int(10*time.Millisecond)
+	}
+	e.addError(expression.Token, CodeInvalidArgument, "Invalid %s argument: %d", expression.Token.Literal, n)
+	return 0
+}
+
+func (e *Evaluator) evalBuiltinArgument(expression *parser.CallNumberExpression, env *Environment) (int, bool) {
+	if len(expression.Arguments) != 1 {
+		e.addError(expression.Token, CodeArity, "%s expects 1 argument, got %d", expression.Token.Literal, len(expression.Arguments))
+		return 0, false
+	}
+	return e.evalNumber(expression.Arguments[0], env), true
+}
+
+// addError reports an error covering token.
+func (e *Evaluator) addError(token parser.Token, code string, format string, args ...interface{}) {
+	e.Errors = append(e.Errors, parser.Diagnostic{
+		Pos:      token.Start,
+		End:      token.Position,
+		Reported: token.Position,
+		Severity: parser.SeverityError,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// addDefinitionError reports an error at a call site and, when definition
+// is known, points at where the called name was defined.
+func (e *Evaluator) addDefinitionError(token parser.Token, definition parser.Node, code string, format string, args ...interface{}) {
+	e.addError(token, code, format, args...)
+	if definition == nil {
+		return
+	}
+	d := &e.Errors[len(e.Errors)-1]
+	pos := definition.StartPos()
+	d.Message += fmt.Sprintf(" (defined at %s:%d:%d)", pos.Filename, pos.Line, pos.Column)
+	d.Related = append(d.Related, parser.Related{Pos: pos, End: definition.EndPos(), Message: "defined here"})
+}
+
+// addNodeError reports an error covering the whole source range of node.
+func (e *Evaluator) addNodeError(node parser.Node, code string, format string, args ...interface{}) {
+	e.Errors = append(e.Errors, parser.Diagnostic{
+		Pos:      node.StartPos(),
+		End:      node.EndPos(),
+		Severity: parser.SeverityError,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// addInternalError reports a recovered panic at the statement that was
+// running, so a bug in the evaluator never takes the host program down.
+func (e *Evaluator) addInternalError(r interface{}) {
+	diagnostic := parser.Diagnostic{
+		Severity: parser.SeverityError,
+		Code:     CodeInternal,
+		Message:  fmt.Sprintf("Internal error: %v", r),
+	}
+	if e.statement != nil {
+		diagnostic.Pos = e.statement.StartPos()
+		diagnostic.End = e.statement.EndPos()
+	}
+	e.Errors = append(e.Errors, diagnostic)
+}
+
+// enter and leave track nested Command and Number calls. Runaway recursion
+// stops the whole evaluation instead of overflowing the Go stack.
+func (e *Evaluator) enter(token parser.Token) {
+	e.depth++
+	if e.depth > MAX_CALL_DEPTH {
+		e.addError(token, CodeCallDepth, "Call depth exceeded: %s", token.Literal)
+		panic(abort{})
+	}
+}
+
+func (e *Evaluator) leave() {
+	e.depth--
+}
+
 func (e *Evaluator) addGIFFrame() {
-	if !e.WithGIF {
+	if e.forever {
+		// Forever adds a single frame per iteration instead.
 		return
 	}
+	e.appendGIFFrame()
+}
 
-	if e.MaxFrames > 0 && len(e.GIF.Image) >= e.MaxFrames {
+func (e *Evaluator) appendGIFFrame() {
+	if !e.WithGIF || e.gifFull() {
 		return
 	}
 
 	scaled := e.scale()
+	if scaled == nil {
+		return
+	}
 	bounds := scaled.Bounds()
-	paletted := image.NewPaletted(bounds, colorPalette(scaled))
-	draw.Draw(paletted, bounds, scaled, bounds.Min, draw.Src)
+	var paletted *image.Paletted
+	if colors, indexes := colorPalette(scaled); colors == nil {
+		paletted = dither(scaled)
+	} else {
+		paletted = image.NewPaletted(bounds, colors)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				c := scaled.At(x, y)
+				index, ok := indexes[c]
+				if !ok {
+					index = colors.Index(c)
+				}
+				paletted.SetColorIndex(x, y, uint8(index))
+			}
+		}
+	}
 
 	e.GIF.Image = append(e.GIF.Image, paletted)
 	e.GIF.Delay = append(e.GIF.Delay, 0)
 }
 
-func colorPalette(img image.Image) color.Palette {
-	palette := make(color.Palette, 0, 256)
+func (e *Evaluator) gifFull() bool {
+	return e.MaxFrames > 0 && len(e.GIF.Image) >= e.MaxFrames
+}
+
+// colorPalette is every color in img with its index, or nil when there are
+// more than the 256 a GIF frame can hold, as there often are with RGB and
+// HSB colors.
+func colorPalette(img image.Image) (color.Palette, map[color.Color]int) {
+	colors := make(color.Palette, 0, 256)
 	bounds := img.Bounds()
-	appended := func(color color.Color) bool {
-		for _, c := range palette {
-			if color == c {
-				return true
+	indexes := map[color.Color]int{}
+
+	for x := 1; x <= bounds.Dx(); x++ {
+		for y := 1; y <= bounds.Dy(); y++ {
+			c := img.At(x, y)
+			if _, ok := indexes[c]; ok {
+				continue
+			}
+			if len(colors) == 256 {
+				return nil, nil
 			}
+			indexes[c] = len(colors)
+			colors = append(colors, c)
 		}
-		return false
 	}
 
-	for x := 1; x <= bounds.Dx(); x++ {
-		for y := 1; y <= bounds.Dy(); y++ {
-			if !appended(img.At(x, y)) {
-				palette = append(palette, img.At(x, y))
+	return colors, indexes
+}
+
+// dither reduces img to the 216 web safe colors with Floyd-Steinberg error
+// diffusion. The palette is a 6x6x6 cube, so the nearest color is found
+// without searching it, which keeps frames with thousands of colors fast.
+func dither(img image.Image) *image.Paletted {
+	bounds := img.Bounds()
+	paletted := image.NewPaletted(bounds, palette.WebSafe)
+	// Errors carried to this row and the next, in 1/16ths, with a column of
+	// padding on either side.
+	current := make([][3]int, bounds.Dx()+2)
+	next := make([][3]int, bounds.Dx()+2)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			i := x - bounds.Min.X + 1
+			r, g, b, _ := img.At(x, y).RGBA()
+			index := 0
+			for channel, value := range [3]uint32{r, g, b} {
+				v := minInt(maxInt(int(value>>8)+current[i][channel]/16, 0), 255)
+				level := (v + 25) / 51
+				index = index*6 + level
+				diff := v - level*51
+				current[i+1][channel] += diff * 7
+				next[i-1][channel] += diff * 3
+				next[i][channel] += diff * 5
+				next[i+1][channel] += diff
 			}
+			paletted.Pix[paletted.PixOffset(x, y)] = uint8(index)
+		}
+		current, next = next, current
+		for i := range next {
+			next[i] = [3]int{}
 		}
 	}
 
-	return palette
+	return paletted
+}
+
+// builtinFiles are the libraries every program can call.
+var builtinFiles = []string{"dbnletters.dbn", "dbngraphics.dbn"}
+
+func isBuiltin(filename string) bool {
+	for _, path := range builtinFiles {
+		if filename == path {
+			return true
+		}
+	}
+	return false
 }
 
 func (e *Evaluator) loadBuiltins(env *Environment) {
-	for _, path := range []string{"dbnletters.dbn", "dbngraphics.dbn"} {
+	for _, path := range builtinFiles {
 		file, _ := builtinsFS.Open("builtins/" + path)
 
 		l := new(parser.Lexer)
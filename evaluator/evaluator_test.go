@@ -8,9 +8,13 @@ import (
 	"image/gif"
 	"image/png"
 	"io"
+	"math"
+	"net"
 	"os"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/tnantoka/dbngo/parser"
 )
@@ -75,7 +79,7 @@ func TestErrors(t *testing.T) {
 		{
 			"Paper 100 Paper 100\n",
 			[]string{
-				"test.dbn:1:11: syntax error",
+				"test.dbn:1:11: syntax error: unexpected `Paper` in Paper statement",
 			},
 		},
 		{
@@ -93,7 +97,7 @@ func TestErrors(t *testing.T) {
 		{
 			"Load \"error.dbn\"\n",
 			[]string{
-				"error.dbn:1:9: syntax error",
+				"error.dbn:1:9: syntax error: unexpected `{` in Command statement, expected name",
 			},
 		},
 		{
@@ -108,6 +112,132 @@ func TestErrors(t *testing.T) {
 				"test.dbn:1:12: Number not found: Test",
 			},
 		},
+		{
+			"Set <Array 0> 100\n",
+			[]string{
+				"test.dbn:1:11: Array index out of range: 0",
+			},
+		},
+		{
+			"Paper <Mouse>",
+			[]string{
+				"test.dbn:1:13: Mouse expects 1 argument, got 0",
+			},
+		},
+		{
+			"Paper <Mouse 4>",
+			[]string{
+				"test.dbn:1:13: Invalid Mouse argument: 4",
+			},
+		},
+		{
+			"Paper <Key 27>",
+			[]string{
+				"test.dbn:1:11: Invalid Key argument: 27",
+			},
+		},
+		{
+			"Paper <Key 1 2>",
+			[]string{
+				"test.dbn:1:11: Key expects 1 argument, got 2",
+			},
+		},
+		{
+			"Paper <Time 5>",
+			[]string{
+				"test.dbn:1:12: Invalid Time argument: 5",
+			},
+		},
+		{
+			"Paper <Time>",
+			[]string{
+				"test.dbn:1:12: Time expects 1 argument, got 0",
+			},
+		},
+		{
+			"Set <Net 0> 100\n",
+			[]string{
+				"test.dbn:1:9: Net slot out of range: 0",
+			},
+		},
+		{
+			"Paper <Net 1001>",
+			[]string{
+				"test.dbn:1:11: Net slot out of range: 1001",
+			},
+		},
+		{
+			"Paper <Array 1001>",
+			[]string{
+				"test.dbn:1:13: Array index out of range: 1001",
+			},
+		},
+		{
+			"Set A 0\nPaper (100 / A)",
+			[]string{
+				"test.dbn:2:8: Division by zero: 100 / A",
+			},
+		},
+		{
+			"Number Test { Value 1 }\nTest",
+			[]string{
+				"test.dbn:2:5: Test is a Number, not a Command (defined at test.dbn:1:1)",
+			},
+		},
+		{
+			"Set Test 1\nTest",
+			[]string{
+				"test.dbn:2:5: Test is a variable, not a Command",
+			},
+		},
+		{
+			"Command Test { }\nPaper <Test>",
+			[]string{
+				"test.dbn:2:12: Test is a Command, not a Number (defined at test.dbn:1:1)",
+			},
+		},
+		{
+			"Command Test { }\nPaper Test",
+			[]string{
+				"test.dbn:2:11: Test is a Command, not a variable (defined at test.dbn:1:1)",
+			},
+		},
+		{
+			"Command Test A { }\nTest 1 2",
+			[]string{
+				"test.dbn:2:5: Test expects 1 argument, got 2 (defined at test.dbn:1:1)",
+			},
+		},
+		{
+			"Number Test { Value 1 }\nPaper <Test 1>",
+			[]string{
+				"test.dbn:2:12: Test expects 0 arguments, got 1 (defined at test.dbn:1:1)",
+			},
+		},
+		{
+			"rectangle 1 2 3",
+			[]string{
+				"test.dbn:1:10: rectangle expects 4 arguments, got 3 (defined at dbngraphics.dbn:8:1)",
+			},
+		},
+		{
+			"Command Test { Test\nTest }\nTest\nPaper 100",
+			[]string{
+				"test.dbn:1:20: Call depth exceeded: Test",
+			},
+		},
+		{
+			"Number Test { Value <Test> }\nPaper <Test>",
+			[]string{
+				"test.dbn:1:26: Call depth exceeded: Test",
+			},
+		},
+		{
+			"Load \"self.dbn\"",
+			[]string{
+				"self.dbn:1:16: Call depth exceeded: self.dbn",
+			},
+		},
 	}
 
 	for i, test := range tests {
@@ -117,16 +247,122 @@ func TestErrors(t *testing.T) {
 
 		if len(e.Errors) != len(test.expected) {
 			t.Errorf("test %d: expected %d errors, got %d", i, len(test.expected), len(e.Errors))
+			continue
 		}
 
 		for j, err := range e.Errors {
-			if err != test.expected[j] {
+			if err.String() != test.expected[j] {
 				t.Errorf("test %d: expected %s, got %s", i, test.expected[j], err)
 			}
 		}
 	}
 }
 
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		input     string
+		code      string
+		line      int
+		column    int
+		endColumn int
+		severity  parser.Severity
+	}{
+		{
+			"Paper 100 Paper 100\n",
+			parser.CodeSyntaxError,
+			1,
+			11,
+			16,
+			parser.SeverityError,
+		},
+		{
+			"\nPaper X\n",
+			CodeNotFound,
+			2,
+			7,
+			8,
+			parser.SeverityError,
+		},
+		{
+			"Paper <Array 0>",
+			CodeOutOfRange,
+			1,
+			8,
+			13,
+			parser.SeverityError,
+		},
+		{
+			"Paper (1 + 1 / 0)",
+			CodeDivisionByZero,
+			1,
+			12,
+			17,
+			parser.SeverityError,
+		},
+	}
+
+	for i, test := range tests {
+		e := New()
+		e.Eval(strings.NewReader(test.input), "test.dbn")
+
+		if len(e.Errors) != 1 {
+			t.Fatalf("test %d: expected 1 error, got %d", i, len(e.Errors))
+		}
+
+		d := e.Errors[0]
+		if d.Code != test.code || d.Severity != test.severity || d.Pos.Filename != "test.dbn" || d.Pos.Line != test.line || d.Pos.Column != test.column || d.End.Line != test.line || d.End.Column != test.endColumn {
+			t.Errorf("test %d: expected %s %s at %d:%d-%d, got %s %s at %d:%d-%d:%d", i, test.severity, test.code, test.line, test.column, test.endColumn, d.Severity, d.Code, d.Pos.Line, d.Pos.Column, d.End.Line, d.End.Column)
+		}
+	}
+}
+
+func TestDefinitionSite(t *testing.T) {
+	e := New()
+	e.Eval(strings.NewReader("Paper 0\n\nNumber Test A {\n  Value A\n}\nTest 1"), "test.dbn")
+
+	if len(e.Errors) != 1 {
+		t.Fatalf("expected 1 error, got %d", len(e.Errors))
+	}
+
+	d := e.Errors[0]
+	if d.Code != CodeKind || d.Pos.Line != 6 {
+		t.Errorf("expected a kind error at line 6, got %s at line %d", d.Code, d.Pos.Line)
+	}
+	if len(d.Related) != 1 {
+		t.Fatalf("expected 1 related location, got %d", len(d.Related))
+	}
+	related := d.Related[0]
+	if related.Pos.Line != 3 || related.Pos.Column != 1 || related.End.Line != 5 || related.End.Column != 2 || related.Message != "defined here" {
+		t.Errorf("expected the definition at 3:1-5:2, got %v-%v %s", related.Pos, related.End, related.Message)
+	}
+}
+
+type panickingMouse struct{}
+
+func (panickingMouse) Mouse(frame int) (int, int, int) {
+	panic("broken mouse")
+}
+
+func TestRecover(t *testing.T) {
+	e := New()
+	e.Mouse = panickingMouse{}
+	img := e.Eval(strings.NewReader("Paper 100\nRepeat A 1 2 {\n  Pen <Mouse 1>\n}"), "test.dbn")
+
+	expected := "test.dbn:3:3: Internal error: broken mouse"
+	if len(e.Errors) != 1 || e.Errors[0].String() != expected {
+		t.Fatalf("expected %s, got %v", expected, e.Errors)
+	}
+	if e.Errors[0].End.Line != 3 || e.Errors[0].End.Column != 16 {
+		t.Errorf("expected the error to end at 3:16, got %v", e.Errors[0].End)
+	}
+
+	actual := imageToBytes(t, img)
+	expectedImage := readBytes(t, "../testdata/black.png")
+	if !bytes.Equal(actual, expectedImage) {
+		t.Errorf("expected the image drawn before the panic")
+	}
+}
+
 func TestScale(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -242,6 +478,160 @@ func TestSet(t *testing.T) {
 	}
 }
 
+func TestArray(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"Set <Array 1> 50\nPaper <Array 1>",
+			"gray.png",
+		},
+		{
+			"Paper <Array 1000>",
+			"white.png",
+		},
+		{
+			"Repeat A 1 10 { Set <Array A> (A * 5) }\nPaper <Array 10>",
+			"gray.png",
+		},
+		{
+			"Command Test { Set <Array 2> 50 }\nTest\nPaper <Array 2>",
+			"gray.png",
+		},
+		{
+			"Set <Array 3> 50\nCommand Test { Paper <Array 3> }\nTest",
+			"gray.png",
+		},
+	}
+
+	for i, test := range tests {
+		e := New()
+		img := e.Eval(strings.NewReader(test.input), "test.dbn")
+
+		if len(e.Errors) > 0 {
+			t.Errorf("test %d: expected no errors, got %v", i, e.Errors)
+		}
+
+		actual := imageToBytes(t, img)
+		expected := readBytes(t, "../testdata/"+test.expected)
+
+		if !bytes.Equal(actual, expected) {
+			t.Errorf("test %d: expected %v, but got %v", i, expected, actual)
+		}
+	}
+}
+
+func TestNet(t *testing.T) {
+	shared := NewMemoryNet()
+
+	tests := []struct {
+		input    string
+		net      NetProvider
+		expected string
+	}{
+		{
+			"Set <Net 1> 50\nPaper <Net 1>",
+			NewMemoryNet(),
+			"gray.png",
+		},
+		{
+			"Set <Net 1> 50\nPaper <Net 1>",
+			nil,
+			"white.png",
+		},
+		{
+			"Set <Net 1> 100",
+			shared,
+			"white.png",
+		},
+		{
+			"Paper <Net 1>",
+			shared,
+			"black.png",
+		},
+	}
+
+	for i, test := range tests {
+		e := New()
+		e.Net = test.net
+		img := e.Eval(strings.NewReader(test.input), "test.dbn")
+
+		if len(e.Errors) > 0 {
+			t.Errorf("test %d: expected no errors, got %v", i, e.Errors)
+		}
+
+		actual := imageToBytes(t, img)
+		expected := readBytes(t, "../testdata/"+test.expected)
+
+		if !bytes.Equal(actual, expected) {
+			t.Errorf("test %d: expected %v, but got %v", i, expected, actual)
+		}
+	}
+}
+
+func TestUDPNet(t *testing.T) {
+	a, err := ListenUDPNet("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := ListenUDPNet("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if err := a.AddPeer(b.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.AddPeer("127.0.0.1:port"); err == nil {
+		t.Errorf("expected an invalid address error")
+	}
+	if _, err := ListenUDPNet("127.0.0.1:port"); err == nil {
+		t.Errorf("expected an invalid address error")
+	}
+	if _, err := ListenUDPNet(a.Addr()); err == nil {
+		t.Errorf("expected an address in use error")
+	}
+
+	garbage, err := net.Dial("udp", b.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer garbage.Close()
+	if _, err := garbage.Write([]byte("garbage")); err != nil {
+		t.Fatal(err)
+	}
+
+	e := New()
+	e.Net = a
+	e.Eval(strings.NewReader("Set <Net 2> 50"), "test.dbn")
+	if len(e.Errors) > 0 {
+		t.Fatalf("expected no errors, got %v", e.Errors)
+	}
+	if err := a.Set(0, 1); err == nil {
+		t.Errorf("expected an out of range error")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		value, _ := b.Get(2)
+		if value == 50 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 50 to arrive, got %d", value)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	a.Close()
+	if err := a.Set(1, 1); err == nil {
+		t.Errorf("expected a closed connection error")
+	}
+}
+
 func TestDot(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -297,7 +687,7 @@ func TestCopy(t *testing.T) {
 
 func TestEvalColor(t *testing.T) {
 	tests := []struct {
-		input    fmt.Stringer
+		input    parser.Expression
 		expected color.Color
 	}{
 		{
@@ -321,7 +711,7 @@ func TestEvalColor(t *testing.T) {
 
 func TestEvalNumber(t *testing.T) {
 	tests := []struct {
-		input    fmt.Stringer
+		input    parser.Expression
 		expected int
 	}{
 		{
@@ -408,6 +798,22 @@ func TestRepeat(t *testing.T) {
 			"Repeat A 10 20 { Line A 10 A 20 }",
 			"square.png",
 		},
+		{
+			"Repeat A 20 10 { Line A 10 A 20 }",
+			"square.png",
+		},
+		{
+			"Set B 20\nRepeat A 10 B { Line A 10 A 20\nSet B 30 }",
+			"square.png",
+		},
+		{
+			"Repeat A 50 50 { Paper A }",
+			"gray.png",
+		},
+		{
+			"Repeat A 100 50 { Paper A }",
+			"gray.png",
+		},
 	}
 
 	for i, test := range tests {
@@ -427,19 +833,32 @@ func TestRepeat(t *testing.T) {
 	}
 }
 
-func TestSame(t *testing.T) {
+func TestLegacyRepeat(t *testing.T) {
 	tests := []struct {
 		input    string
 		expected string
 	}{
 		{
-			"Same? 1 1 { Line 0 0 100 100 }",
-			"diagonal.png",
+			"Repeat A 10 20 { Line A 10 A 20 }",
+			"square.png",
+		},
+		{
+			"Repeat A 100 50 { Paper A }",
+			"white.png",
+		},
+		{
+			"Set B 50\nRepeat A 0 B { Set B 10\nPaper A }",
+			"lightgray.png",
+		},
+		{
+			"Number Test {\n  Repeat A 0 100 {\n    Same? A 50 {\n      Value A\n    }\n  }\n}\nPaper <Test>",
+			"gray.png",
 		},
 	}
 
 	for i, test := range tests {
 		e := New()
+		e.LegacyRepeat = true
 		img := e.Eval(strings.NewReader(test.input), "test.dbn")
 
 		if len(e.Errors) > 0 {
@@ -455,13 +874,13 @@ func TestSame(t *testing.T) {
 	}
 }
 
-func TestNotSame(t *testing.T) {
+func TestSame(t *testing.T) {
 	tests := []struct {
 		input    string
 		expected string
 	}{
 		{
-			"NotSame? 0 1 { Line 0 0 100 100 }",
+			"Same? 1 1 { Line 0 0 100 100 }",
 			"diagonal.png",
 		},
 	}
@@ -483,7 +902,35 @@ func TestNotSame(t *testing.T) {
 	}
 }
 
-func TestSmaller(t *testing.T) {
+func TestNotSame(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"NotSame? 0 1 { Line 0 0 100 100 }",
+			"diagonal.png",
+		},
+	}
+
+	for i, test := range tests {
+		e := New()
+		img := e.Eval(strings.NewReader(test.input), "test.dbn")
+
+		if len(e.Errors) > 0 {
+			t.Errorf("test %d: expected no errors, got %v", i, e.Errors)
+		}
+
+		actual := imageToBytes(t, img)
+		expected := readBytes(t, "../testdata/"+test.expected)
+
+		if !bytes.Equal(actual, expected) {
+			t.Errorf("test %d: expected %v, but got %v", i, expected, actual)
+		}
+	}
+}
+
+func TestSmaller(t *testing.T) {
 	tests := []struct {
 		input    string
 		expected string
@@ -661,6 +1108,34 @@ func TestNumber(t *testing.T) {
 			"Number Test A { Set B (A + 25)\nValue B }\nPaper <Test 25>",
 			"gray.png",
 		},
+		{
+			"Number Abs A {\n  Smaller? A 0 {\n    Value (0 - A)\n  }\n  Value A\n}\nPaper <Abs (0 - 50)>",
+			"gray.png",
+		},
+		{
+			"Number Max A B {\n  Smaller? A B {\n    Value B\n  }\n  Value A\n}\nPaper <Max 100 50>",
+			"black.png",
+		},
+		{
+			"Number Root A {\n  Repeat B 0 A {\n    Same? (B * B) A {\n      Value B\n    }\n  }\n}\nPaper <Root 2500>",
+			"gray.png",
+		},
+		{
+			"Number Test {\n  Forever {\n    Value 50\n  }\n}\nPaper <Test>",
+			"gray.png",
+		},
+		{
+			"Number Test {\n  Value 50\n  Paper 100\n}\nPaper <Test>",
+			"gray.png",
+		},
+		{
+			"Number Test {\n  Smaller? 1 0 {\n    Value 50\n  }\n}\nPaper <Test>",
+			"white.png",
+		},
+		{
+			"Command Test {\n  Value 0\n  Paper 50\n}\nTest\nValue 100",
+			"gray.png",
+		},
 	}
 
 	for i, test := range tests {
@@ -680,35 +1155,55 @@ func TestNumber(t *testing.T) {
 	}
 }
 
-func TestGIF(t *testing.T) {
+func TestMouse(t *testing.T) {
 	tests := []struct {
-		input     string
-		expected  string
-		MaxFrames int
+		input    string
+		timeline string
+		expected string
 	}{
 		{
-			"Repeat C 0 10 { Paper C }",
-			"gradation.gif",
-			0,
+			"Paper <Mouse 1>",
+			"",
+			"white.png",
 		},
 		{
-			"Repeat C 0 10 { Paper C }",
-			"gradation-half.gif",
-			5,
+			"Paper <Mouse 1>",
+			"0 50 10 100",
+			"gray.png",
+		},
+		{
+			"Paper <Mouse 2>",
+			"// frame x y button\n0 50 10 100\n",
+			"lightgray.png",
+		},
+		{
+			"Paper <mouse 3>",
+			"\n0 50 10 100\n1 0 0 0",
+			"black.png",
+		},
+		{
+			"Number mouse N { Value 50 }\nPaper <mouse 1>",
+			"",
+			"gray.png",
 		},
 	}
 
 	for i, test := range tests {
 		e := New()
-		e.WithGIF = true
-		e.MaxFrames = test.MaxFrames
-		e.Eval(strings.NewReader(test.input), "test.dbn")
+		if test.timeline != "" {
+			timeline, err := ReadMouseTimeline(strings.NewReader(test.timeline))
+			if err != nil {
+				t.Fatalf("test %d: %s", i, err)
+			}
+			e.Mouse = timeline
+		}
+		img := e.Eval(strings.NewReader(test.input), "test.dbn")
 
 		if len(e.Errors) > 0 {
 			t.Errorf("test %d: expected no errors, got %v", i, e.Errors)
 		}
 
-		actual := gifToBytes(t, e.GIF)
+		actual := imageToBytes(t, img)
 		expected := readBytes(t, "../testdata/"+test.expected)
 
 		if !bytes.Equal(actual, expected) {
@@ -717,19 +1212,100 @@ func TestGIF(t *testing.T) {
 	}
 }
 
-func TestBuiltins(t *testing.T) {
+func TestMouseTimeline(t *testing.T) {
+	timeline, err := ReadMouseTimeline(strings.NewReader("10 3 4 0\n0 1 2 100\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		frame    int
+		expected [3]int
+	}{
+		{-1, [3]int{0, 0, 0}},
+		{0, [3]int{1, 2, 100}},
+		{9, [3]int{1, 2, 100}},
+		{10, [3]int{3, 4, 0}},
+		{100, [3]int{3, 4, 0}},
+	}
+
+	for i, test := range tests {
+		x, y, button := timeline.Mouse(test.frame)
+		if [3]int{x, y, button} != test.expected {
+			t.Errorf("test %d: expected %v, got %v", i, test.expected, [3]int{x, y, button})
+		}
+	}
+}
+
+func TestMouseTimelineErrors(t *testing.T) {
 	tests := []struct {
 		input    string
 		expected string
 	}{
 		{
-			"letterA 10 10",
-			"letter.png",
+			"0 1 2",
+			"line 1: expected a frame and 3 values, got 3 fields",
+		},
+		{
+			"\n0 1 2 a",
+			"line 2: invalid number: a",
+		},
+		{
+			strings.Repeat("0", 1<<16),
+			"bufio.Scanner: token too long",
+		},
+	}
+
+	for i, test := range tests {
+		_, err := ReadMouseTimeline(strings.NewReader(test.input))
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("test %d: expected %s, got %v", i, test.expected, err)
+		}
+	}
+}
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		input    string
+		timeline string
+		expected string
+	}{
+		{
+			"Paper <Key 1>",
+			"",
+			"white.png",
+		},
+		{
+			"Paper <Key 1>",
+			"0 1 26",
+			"black.png",
+		},
+		{
+			"Paper <key 2>",
+			"0 1 26",
+			"white.png",
+		},
+		{
+			"Number key N { Value 100 }\nPaper <key 2>",
+			"0 1 26",
+			"black.png",
+		},
+		{
+			"Paper <Key 26>",
+			"0 1 26\n1",
+			"black.png",
 		},
 	}
 
 	for i, test := range tests {
 		e := New()
+		if test.timeline != "" {
+			timeline, err := ReadKeyTimeline(strings.NewReader(test.timeline))
+			if err != nil {
+				t.Fatalf("test %d: %s", i, err)
+			}
+			e.Key = timeline
+		}
 		img := e.Eval(strings.NewReader(test.input), "test.dbn")
 
 		if len(e.Errors) > 0 {
@@ -745,86 +1321,1439 @@ func TestBuiltins(t *testing.T) {
 	}
 }
 
-func TestExamples(t *testing.T) {
-	var testDirectory func(string)
-	testDirectory = func(dir string) {
-		entries, err := os.ReadDir("../examples" + dir)
-		if err != nil {
-			t.Fatal(err)
+func TestKeyTimeline(t *testing.T) {
+	timeline, err := ReadKeyTimeline(strings.NewReader("0 1\n5 1 2\n10\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		frame    int
+		key      int
+		expected bool
+	}{
+		{0, 1, true},
+		{0, 2, false},
+		{5, 1, true},
+		{7, 2, true},
+		{10, 1, false},
+	}
+
+	for i, test := range tests {
+		if timeline.Key(test.frame, test.key) != test.expected {
+			t.Errorf("test %d: expected %v", i, test.expected)
 		}
+	}
 
-		for _, entry := range entries {
-			if entry.IsDir() {
-				testDirectory(dir + entry.Name() + "/")
-				continue
-			}
+	_, err = ReadKeyTimeline(strings.NewReader("0 a"))
+	if err == nil || err.Error() != "line 1: invalid number: a" {
+		t.Errorf("expected invalid number error, got %v", err)
+	}
 
-			e := New()
+	_, err = ReadKeyTimeline(strings.NewReader("0" + strings.Repeat(" 1", KEY_COUNT+1)))
+	if err == nil || err.Error() != "line 1: expected a frame and 0 to 26 values, got 28 fields" {
+		t.Errorf("expected too many keys error, got %v", err)
+	}
+}
 
-			file, err := os.Open("../examples" + dir + entry.Name())
-			if err != nil {
-				t.Fatal(err)
-			}
+func TestTime(t *testing.T) {
+	clock := FixedClock{Start: time.Date(2000, 1, 1, 10, 50, 0, int(500*time.Millisecond), time.UTC)}
 
-			img := e.Eval(file, entry.Name())
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"Paper <Time 1>",
+			"lightgray.png",
+		},
+		{
+			"Paper <Time 2>",
+			"gray.png",
+		},
+		{
+			"Paper <time 3>",
+			"white.png",
+		},
+		{
+			"Paper <Time 4>",
+			"gray.png",
+		},
+		{
+			"Number time { Value 50 }\nPaper <time>",
+			"gray.png",
+		},
+	}
 
-			if len(e.Errors) > 0 {
-				t.Errorf("test %v: expected no errors, got %v", entry.Name(), e.Errors)
-			}
+	for i, test := range tests {
+		e := New()
+		e.Clock = clock
+		img := e.Eval(strings.NewReader(test.input), "test.dbn")
 
-			actual := imageToBytes(t, img)
-			expected := readBytes(t, "../testdata/examples"+dir+strings.Replace(entry.Name(), ".dbn", ".png", 1))
+		if len(e.Errors) > 0 {
+			t.Errorf("test %d: expected no errors, got %v", i, e.Errors)
+		}
 
-			if !bytes.Equal(actual, expected) {
-				t.Errorf("test %v: expected %v, but got %v", entry.Name(), expected, actual)
-			}
+		actual := imageToBytes(t, img)
+		expected := readBytes(t, "../testdata/"+test.expected)
+
+		if !bytes.Equal(actual, expected) {
+			t.Errorf("test %d: expected %v, but got %v", i, expected, actual)
 		}
 	}
-	testDirectory("/")
 }
 
-func imageToBytes(t *testing.T, img image.Image) []byte {
-	buf := new(bytes.Buffer)
-	err := png.Encode(buf, img)
-	if err != nil {
-		t.Fatalf("failed to encode image: %s", err)
+func TestClock(t *testing.T) {
+	start := time.Date(2000, 1, 1, 10, 50, 0, 0, time.UTC)
+	clock := FixedClock{Start: start, Step: time.Second}
+	if !clock.Now(3).Equal(start.Add(3 * time.Second)) {
+		t.Errorf("expected %v, got %v", start.Add(3*time.Second), clock.Now(3))
 	}
 
-	debugFile, err := os.Create("../tmp/debug.png")
-	if err != nil {
-		t.Fatalf("failed to create debug file: %s", err)
+	e := New()
+	e.Clock = nil
+	e.Eval(strings.NewReader("Set A <Time 1>"), "test.dbn")
+	if len(e.Errors) > 0 {
+		t.Errorf("expected no errors, got %v", e.Errors)
 	}
-	defer debugFile.Close()
 
-	if err := png.Encode(debugFile, img); err != nil {
-		t.Fatalf("failed to encode debug image: %s", err)
+	if (WallClock{}).Now(0).IsZero() {
+		t.Errorf("expected wall time")
 	}
-
-	return buf.Bytes()
 }
 
-func gifToBytes(t *testing.T, g *gif.GIF) []byte {
-	buf := new(bytes.Buffer)
-	err := gif.EncodeAll(buf, g)
+func TestForever(t *testing.T) {
+	mouse, err := ReadMouseTimeline(strings.NewReader("0 0 0 0\n1 50 0 0\n2 100 0 0"))
 	if err != nil {
-		t.Fatalf("failed to encode image: %s", err)
+		t.Fatal(err)
 	}
 
-	debugFile, err := os.Create("../tmp/debug.gif")
-	if err != nil {
-		t.Fatalf("failed to create debug file: %s", err)
+	tests := []struct {
+		input    string
+		frames   int
+		expected string
+	}{
+		{
+			"Forever { Paper <Mouse 1> }",
+			2,
+			"gray.png",
+		},
+		{
+			"Forever { Paper <Mouse 1> }",
+			0,
+			"white.png",
+		},
+		{
+			"Forever\n{\nPaper <Time 3>\n}",
+			6,
+			"gray.png",
+		},
+		{
+			"Set A 0\nForever { Set A (A + 10)\nForever { Paper A } }",
+			5,
+			"gray.png",
+		},
 	}
-	defer debugFile.Close()
 
-	if err := gif.EncodeAll(debugFile, g); err != nil {
-		t.Fatalf("failed to encode debug gif: %s", err)
-	}
+	for i, test := range tests {
+		e := New()
+		e.Mouse = mouse
+		e.Clock = FixedClock{Start: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), Step: 10 * time.Second}
+		e.ForeverFrames = test.frames
+		img := e.Eval(strings.NewReader(test.input), "test.dbn")
 
-	return buf.Bytes()
-}
+		if len(e.Errors) > 0 {
+			t.Errorf("test %d: expected no errors, got %v", i, e.Errors)
+		}
 
-func readBytes(t *testing.T, path string) []byte {
-	t.Helper()
+		actual := imageToBytes(t, img)
+		expected := readBytes(t, "../testdata/"+test.expected)
+
+		if !bytes.Equal(actual, expected) {
+			t.Errorf("test %d: expected %v, but got %v", i, expected, actual)
+		}
+	}
+
+	e := New()
+	e.Eval(strings.NewReader("Forever { Paper X }"), "test.dbn")
+	if len(e.Errors) != 1 {
+		t.Errorf("expected Forever to stop after an error, got %v", e.Errors)
+	}
+}
+
+func TestSize(t *testing.T) {
+	tests := []struct {
+		input  string
+		width  int
+		height int
+		size   image.Point
+		black  image.Point
+	}{
+		{
+			"Set [0 1] 100",
+			100,
+			100,
+			image.Point{100, 100},
+			image.Point{0, 99},
+		},
+		{
+			"Line 10 20 10 20",
+			300,
+			200,
+			image.Point{300, 200},
+			image.Point{10, 180},
+		},
+		{
+			"Size 40 60\nSet [5 10] 100\nSet A [5 10]\nSet [(A / 10) 1] 100",
+			100,
+			100,
+			image.Point{40, 60},
+			image.Point{10, 59},
+		},
+	}
+
+	for i, test := range tests {
+		e := New()
+		e.Width = test.width
+		e.Height = test.height
+		img := e.Eval(strings.NewReader(test.input), "test.dbn")
+
+		if len(e.Errors) > 0 {
+			t.Errorf("test %d: expected no errors, got %v", i, e.Errors)
+		}
+
+		if img.Bounds().Size() != test.size {
+			t.Errorf("test %d: expected size %v, got %v", i, test.size, img.Bounds().Size())
+		}
+
+		black := color.RGBA{0, 0, 0, 255}
+		if img.At(test.black.X, test.black.Y) != black {
+			t.Errorf("test %d: expected black at %v, got %v", i, test.black, img.At(test.black.X, test.black.Y))
+		}
+	}
+
+	for i, input := range []string{"Size 0 100", "Size 100 (0 - 1)", "Size 4097 100", "Size 100 4097"} {
+		e := New()
+		e.Eval(strings.NewReader(input), "test.dbn")
+
+		if len(e.Errors) != 1 || e.Errors[0].Code != CodeInvalidArgument {
+			t.Errorf("test %d: expected an invalid size error, got %v", i, e.Errors)
+		}
+	}
+}
+
+func TestGIF(t *testing.T) {
+	tests := []struct {
+		input     string
+		expected  string
+		MaxFrames int
+	}{
+		{
+			"Repeat C 0 10 { Paper C }",
+			"gradation.gif",
+			0,
+		},
+		{
+			"Repeat C 0 10 { Paper C }",
+			"gradation-half.gif",
+			5,
+		},
+		{
+			"Set C 0\nForever { Paper C\nSet C (C + 1) }",
+			"gradation.gif",
+			0,
+		},
+		{
+			"Set C 0\nForever { Paper C\nSet C (C + 1) }",
+			"gradation-half.gif",
+			5,
+		},
+		{
+			"Paper 50\nSize 100 100\nRepeat C 0 10 { Paper C }",
+			"gradation.gif",
+			0,
+		},
+	}
+
+	for i, test := range tests {
+		e := New()
+		e.WithGIF = true
+		e.MaxFrames = test.MaxFrames
+		e.ForeverFrames = 11
+		e.Eval(strings.NewReader(test.input), "test.dbn")
+
+		if len(e.Errors) > 0 {
+			t.Errorf("test %d: expected no errors, got %v", i, e.Errors)
+		}
+
+		actual := gifToBytes(t, e.GIF)
+		expected := readBytes(t, "../testdata/"+test.expected)
+
+		if !bytes.Equal(actual, expected) {
+			t.Errorf("test %d: expected %v, but got %v", i, expected, actual)
+		}
+	}
+}
+
+func TestGIFColor(t *testing.T) {
+	e := New()
+	e.WithGIF = true
+	e.ColorMode = ColorRGB
+	e.Eval(strings.NewReader("Repeat A 0 100 {\n  Repeat B 0 5 {\n    Set [A B] A B 50\n  }\n}"), "test.dbn")
+
+	if len(e.Errors) > 0 {
+		t.Errorf("expected no errors, got %v", e.Errors)
+	}
+
+	decoded, err := gif.DecodeAll(bytes.NewReader(gifToBytes(t, e.GIF)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Image) != len(e.GIF.Image) {
+		t.Fatalf("expected %d frames, got %d", len(e.GIF.Image), len(decoded.Image))
+	}
+
+	last := decoded.Image[len(decoded.Image)-1]
+	if len(last.Palette) > 256 {
+		t.Errorf("expected at most 256 colors, got %d", len(last.Palette))
+	}
+	r, g, b, _ := last.At(90, 97).RGBA()
+	if r>>8 < 192 || g>>8 > 64 || b>>8 < 64 || b>>8 > 192 {
+		t.Errorf("expected a purplish red near the bottom right, got %v", last.At(90, 97))
+	}
+}
+
+func TestSVG(t *testing.T) {
+	header := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="100" viewBox="0 0 100 100">` + "\n"
+	paper := `<rect width="100" height="100" fill="#ffffff"/>` + "\n"
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"",
+			header + paper + "</svg>\n",
+		},
+		{
+			"Line 10 10 90 90",
+			header + paper +
+				`<path d="M10.5 90.5L90.5 10.5" fill="none" stroke-width="1" stroke-linecap="square" stroke="#000000"/>` + "\n" +
+				"</svg>\n",
+		},
+		{
+			"Width 4\nLine 10 10 90 10\nWidth 3\nLine 10 10 90 10",
+			header + paper +
+				`<path d="M11 91L91 91" fill="none" stroke-width="4" stroke-linecap="round" stroke="#000000"/>` + "\n" +
+				`<path d="M10.5 90.5L90.5 90.5" fill="none" stroke-width="3" stroke-linecap="round" stroke="#000000"/>` + "\n" +
+				"</svg>\n",
+		},
+		{
+			"Set [20 30] 50\nWidth 5\nSet [20 30] 100",
+			header + paper +
+				`<rect x="20" y="70" width="1" height="1" fill="#7f7f7f"/>` + "\n" +
+				`<rect x="20" y="70" width="1" height="1" fill="#000000"/>` + "\n" +
+				"</svg>\n",
+		},
+		{
+			"Line 10 10 90 90\nPaper 50\nBlend multiply\nPen 100 50\nLine 0 50 100 50\nPaper 100 10",
+			header +
+				`<rect width="100" height="100" fill="#7f7f7f"/>` + "\n" +
+				`<path d="M0.5 50.5L100.5 50.5" fill="none" stroke-width="1" stroke-linecap="square" stroke="#000000" stroke-opacity="0.498" style="mix-blend-mode:multiply"/>` + "\n" +
+				`<rect width="100" height="100" fill="#000000" fill-opacity="0.098" style="mix-blend-mode:multiply"/>` + "\n" +
+				"</svg>\n",
+		},
+		{
+			"Line 10 10 90 90\nSize 20 10\nLine 0 0 10 10",
+			`<svg xmlns="http://www.w3.org/2000/svg" width="20" height="10" viewBox="0 0 20 10">` + "\n" +
+				`<rect width="20" height="10" fill="#ffffff"/>` + "\n" +
+				`<path d="M0.5 10.5L10.5 0.5" fill="none" stroke-width="1" stroke-linecap="square" stroke="#000000"/>` + "\n" +
+				"</svg>\n",
+		},
+		{
+			"Set C 0\nForever { Paper C\nSet [C C] 100\nSet C (C + 1) }",
+			header +
+				`<rect width="100" height="100" fill="#e5e5e5"/>` + "\n" +
+				`<rect x="10" y="90" width="1" height="1" fill="#000000"/>` + "\n" +
+				"</svg>\n",
+		},
+	}
+
+	for i, test := range tests {
+		e := New()
+		e.WithDrawing = true
+		e.ForeverFrames = 11
+		e.Eval(strings.NewReader(test.input), "test.dbn")
+
+		if len(e.Errors) > 0 {
+			t.Errorf("test %d: expected no errors, got %v", i, e.Errors)
+		}
+
+		var b bytes.Buffer
+		if err := EncodeSVG(&b, e.Drawing, e.Scale); err != nil {
+			t.Errorf("test %d: %s", i, err)
+		}
+		if b.String() != test.expected {
+			t.Errorf("test %d: expected %s, got %s", i, test.expected, b.String())
+		}
+	}
+
+	e := New()
+	e.Eval(strings.NewReader("Line 0 0 100 100"), "test.dbn")
+	if e.Drawing != nil {
+		t.Errorf("expected no drawing without WithDrawing, got %v", e.Drawing)
+	}
+
+	e = New()
+	e.WithDrawing = true
+	e.Scale = 3
+	e.Eval(strings.NewReader("Line 0 0 100 100"), "test.dbn")
+	e.Eval(strings.NewReader("Line 0 0"), "test.dbn")
+	if len(e.Drawing.Operations) != 0 {
+		t.Errorf("expected no operations after a syntax error, got %v", e.Drawing.Operations)
+	}
+
+	var b bytes.Buffer
+	EncodeSVG(&b, &Drawing{Width: 10, Height: 20}, 0)
+	expected := `<svg xmlns="http://www.w3.org/2000/svg" width="10" height="20" viewBox="0 0 10 20">` + "\n</svg>\n"
+	if b.String() != expected {
+		t.Errorf("expected %s, got %s", expected, b.String())
+	}
+
+	if err := EncodeSVG(&b, nil, 1); err == nil || err.Error() != "no drawing: set WithDrawing before Eval" {
+		t.Errorf("expected no drawing, got %v", err)
+	}
+	if err := EncodeSVG(&b, &Drawing{Height: 10}, 1); err == nil || err.Error() != "invalid drawing size: 0x10" {
+		t.Errorf("expected invalid drawing size, got %v", err)
+	}
+
+	// Only built-in shapes such as circle draw wide dots.
+	b.Reset()
+	EncodeSVG(&b, &Drawing{Width: 100, Height: 100, Operations: []Operation{
+		{Kind: OperationDot, X1: 20, Y1: 70, X2: 20, Y2: 70, Color: color.NRGBA{0, 0, 0, 255}, Width: 5},
+	}}, 1)
+	expected = `<circle cx="20.5" cy="70.5" r="2.5" fill="#000000"/>`
+	if !strings.Contains(b.String(), expected) {
+		t.Errorf("expected %s in %s", expected, b.String())
+	}
+}
+
+func TestPDF(t *testing.T) {
+	tests := []struct {
+		input    string
+		page     PageSize
+		expected []string
+	}{
+		{
+			"",
+			PageSize{100, 100},
+			[]string{
+				"/MediaBox [0 0 100 100]",
+				"/GS0 << /Type /ExtGState /CA 1 /ca 1 /BM /Normal >>",
+				"1 0 0 -1 0 100 cm\n0 0 100 100 re W n\n/GS0 gs\n1 1 1 rg\n0 0 100 100 re f\n",
+			},
+		},
+		{
+			"Line 10 10 90 90\nWidth 4\nLine 10 10 90 10",
+			PageSize{200, 400},
+			[]string{
+				"2 0 0 -2 0 300 cm\n",
+				"0 0 0 RG\n1 w 2 J\n10.5 90.5 m 90.5 10.5 l S\n",
+				"0 0 0 RG\n4 w 1 J\n11 91 m 91 91 l S\n",
+			},
+		},
+		{
+			"Set [20 30] 50\nWidth 2\nSet [20 30] 100\ncircle 20 30 0 100",
+			PageSize{100, 100},
+			[]string{
+				"0.498 0.498 0.498 rg\n20 70 1 1 re f\n",
+				"0 0 0 rg\n20 70 1 1 re f\n",
+				"0 0 0 rg\n22 71 m\n22 71.552 21.552 72 21 72 c\n",
+			},
+		},
+		{
+			"Blend multiply\nPen 100 50\nLine 0 50 100 50\nBlend add\nSet [1 1] 100",
+			PageSize{100, 100},
+			[]string{
+				"/GS1 << /Type /ExtGState /CA 0.498 /ca 0.498 /BM /Multiply >>",
+				"/GS2 << /Type /ExtGState /CA 1 /ca 1 /BM /Screen >>",
+				"/GS1 gs\n0 0 0 RG\n1 w 2 J\n0.5 50.5 m 100.5 50.5 l S\n/GS2 gs\n",
+			},
+		},
+	}
+
+	for i, test := range tests {
+		e := New()
+		e.WithDrawing = true
+		e.Eval(strings.NewReader(test.input), "test.dbn")
+
+		if len(e.Errors) > 0 {
+			t.Errorf("test %d: expected no errors, got %v", i, e.Errors)
+		}
+
+		var b bytes.Buffer
+		if err := EncodePDF(&b, e.Drawing, test.page); err != nil {
+			t.Errorf("test %d: %s", i, err)
+		}
+		actual := b.String()
+		for _, expected := range test.expected {
+			if !strings.Contains(actual, expected) {
+				t.Errorf("test %d: expected %q in %s", i, expected, actual)
+			}
+		}
+
+		xref := strings.Index(actual, "xref\n")
+		for n, entry := range strings.Split(actual[xref:], "\n")[3:7] {
+			offset, _ := strconv.Atoi(entry[:10])
+			if !strings.HasPrefix(actual[offset:], strconv.Itoa(n+1)+" 0 obj") {
+				t.Errorf("test %d: expected object %d at %d", i, n+1, offset)
+			}
+		}
+		if !strings.HasSuffix(actual, "startxref\n"+strconv.Itoa(xref)+"\n%%EOF\n") {
+			t.Errorf("test %d: expected startxref %d", i, xref)
+		}
+	}
+
+	var b bytes.Buffer
+	if err := EncodePDF(&b, nil, PageSize{100, 100}); err == nil || err.Error() != "no drawing: set WithDrawing before Eval" {
+		t.Errorf("expected no drawing, got %v", err)
+	}
+}
+
+func TestParsePageSize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected PageSize
+		err      string
+	}{
+		{"letter", PageSize{612, 792}, ""},
+		{"A4", PageSize{210 * 72 / 25.4, 297 * 72 / 25.4}, ""},
+		{"8x10in", PageSize{576, 720}, ""},
+		{"254x127mm", PageSize{720, 360}, ""},
+		{"8x10cm", PageSize{}, "invalid page size unit: cm"},
+		{"0x10in", PageSize{}, "invalid page size: 0x10in"},
+		{"b5", PageSize{}, "invalid page size: b5"},
+	}
+
+	for i, test := range tests {
+		actual, err := ParsePageSize(test.input)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("test %d: expected %s, got %v", i, test.err, err)
+			}
+			continue
+		}
+		if err != nil || math.Abs(actual.Width-test.expected.Width) > 0.001 || math.Abs(actual.Height-test.expected.Height) > 0.001 {
+			t.Errorf("test %d: expected %v, got %v %v", i, test.expected, actual, err)
+		}
+	}
+}
+
+func TestPlot(t *testing.T) {
+	tests := []struct {
+		input    string
+		options  PlotOptions
+		expected string
+	}{
+		{
+			"Paper 100\nPen 0\nLine 10 10 90 10",
+			PlotOptions{PlotHPGL, 100, 100, 0},
+			"IN;\nPU;\nSP0;\n",
+		},
+		{
+			"Line 10 10 90 10\nLine 90 90 10 90\nLine 90 10 90 90",
+			PlotOptions{PlotHPGL, 100, 100, 0},
+			"IN;\nSP1;\nPU420,380;\nPD3620,380;\nPD3620,3580;\nPD420,3580;\nPU;\nSP0;\n",
+		},
+		{
+			"Pen 50\nSet [20 20] 30\nLine 20 20 20 30\nPen 100\nLine 0 0 100 100\nSet [200 200] 100\nLine 150 0 150 100\nLine 150 0 200 50",
+			PlotOptions{PlotHPGL, 200, 100, 0},
+			"IN;\nSP1;\nPU40,0;\nPD4000,3960;\nSP2;\nPU820,1180;\nPD820,780;\nSP3;\nPU820,780;\nPD820,780;\nPU;\nSP0;\n",
+		},
+		{
+			"Pen 50\nSet [20 20] 30\nLine 20 20 20 30\nPen 100 50\nLine 10 10 10 10",
+			PlotOptions{PlotHPGL, 100, 100, 2},
+			"IN;\nSP1;\nPU420,380;\nPD420,380;\nPU820,780;\nPD820,780;\nPD820,1180;\nPU;\nSP0;\n",
+		},
+		{
+			"Line 10 10 90 10\nPen 50\nSet [50 50] 50",
+			PlotOptions{PlotGCode, 50, 100, 0},
+			"G21\nG90\n" +
+				"G0 Z5\nM0 (pen 1, gray 100)\nG0 Z5\nG0 X5.25 Y4.75\nG1 Z0\nG1 X45.25 Y4.75\n" +
+				"G0 Z5\nM0 (pen 2, gray 50)\nG0 Z5\nG0 X25.25 Y24.75\nG1 Z0\nG1 X25.25 Y24.75\n" +
+				"G0 Z5\nG0 X0 Y0\nM2\n",
+		},
+	}
+
+	for i, test := range tests {
+		e := New()
+		e.WithDrawing = true
+		e.Eval(strings.NewReader(test.input), "test.dbn")
+
+		if len(e.Errors) > 0 {
+			t.Errorf("test %d: expected no errors, got %v", i, e.Errors)
+		}
+
+		var b bytes.Buffer
+		if err := EncodePlot(&b, e.Drawing, test.options); err != nil {
+			t.Errorf("test %d: %s", i, err)
+		}
+		if b.String() != test.expected {
+			t.Errorf("test %d: expected %q, got %q", i, test.expected, b.String())
+		}
+	}
+
+	var b bytes.Buffer
+	if err := EncodePlot(&b, &Drawing{Width: 10}, PlotOptions{BedWidth: 100, BedHeight: 100}); err == nil || err.Error() != "invalid drawing size: 10x0" {
+		t.Errorf("expected invalid drawing size, got %v", err)
+	}
+
+	for _, format := range []PlotFormat{PlotHPGL, PlotGCode} {
+		parsed, err := ParsePlotFormat(format.String())
+		if err != nil || parsed != format {
+			t.Errorf("expected %s, got %s %v", format, parsed, err)
+		}
+	}
+	if _, err := ParsePlotFormat("svg"); err == nil || err.Error() != "unknown plot format: svg" {
+		t.Errorf("expected unknown plot format, got %v", err)
+	}
+	if PlotFormat(9).String() != "PlotFormat(9)" {
+		t.Errorf("expected PlotFormat(9), got %s", PlotFormat(9))
+	}
+}
+
+// spyCanvas records the calls the evaluator makes instead of drawing.
+type spyCanvas struct {
+	bounds image.Rectangle
+	calls  []string
+}
+
+func (c *spyCanvas) Clear(style Style) {
+	c.calls = append(c.calls, fmt.Sprintf("Clear %v", style))
+}
+
+func (c *spyCanvas) Line(x1 int, y1 int, x2 int, y2 int, style Style) {
+	c.calls = append(c.calls, fmt.Sprintf("Line %d %d %d %d %v", x1, y1, x2, y2, style))
+}
+
+func (c *spyCanvas) Dot(x int, y int, style Style) {
+	c.calls = append(c.calls, fmt.Sprintf("Dot %d %d %v", x, y, style))
+}
+
+func (c *spyCanvas) Sample(x int, y int) color.Color {
+	c.calls = append(c.calls, fmt.Sprintf("Sample %d %d", x, y))
+	return color.RGBA{0, 0, 0, 255}
+}
+
+func TestCanvas(t *testing.T) {
+	var canvases []*spyCanvas
+	e := New()
+	e.WithGIF = true
+	e.NewCanvas = func(bounds image.Rectangle) Canvas {
+		c := &spyCanvas{bounds: bounds}
+		canvases = append(canvases, c)
+		return c
+	}
+	img := e.Eval(strings.NewReader("Paper 50\nWidth 3\nAntialias on\nLine 0 0 10 10\nBlend multiply\nSet [5 5] 100\nSet A [5 5]\nSize 20 10\nSet [1 1] A"), "test.dbn")
+
+	if len(e.Errors) > 0 {
+		t.Fatalf("expected no errors, got %v", e.Errors)
+	}
+	if img != nil {
+		t.Errorf("expected no image from a canvas that is not an image, got %v", img)
+	}
+	if len(e.GIF.Image) != 0 {
+		t.Errorf("expected no GIF frames, got %d", len(e.GIF.Image))
+	}
+	if len(canvases) != 2 || canvases[0].bounds != image.Rect(0, 0, 100, 100) || canvases[1].bounds != image.Rect(0, 0, 20, 10) {
+		t.Fatalf("expected canvases for 100x100 and 20x10, got %v", canvases)
+	}
+
+	expected := []string{
+		"Clear {{255 255 255 255} 1 false normal}",
+		"Clear {{127 127 127 255} 1 false normal}",
+		"Line 0 100 10 90 {{0 0 0 255} 3 true normal}",
+		"Dot 5 95 {{0 0 0 255} 1 true multiply}",
+		"Sample 5 95",
+	}
+	if fmt.Sprint(canvases[0].calls) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, canvases[0].calls)
+	}
+	expected = []string{
+		"Clear {{255 255 255 255} 1 false normal}",
+		"Dot 1 9 {{0 0 0 255} 1 true multiply}",
+	}
+	if fmt.Sprint(canvases[1].calls) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, canvases[1].calls)
+	}
+
+	e = New()
+	e.NewCanvas = nil
+	e.Eval(strings.NewReader("Line 0 0 100 100"), "test.dbn")
+	if _, ok := e.canvas.(*RasterCanvas); !ok {
+		t.Errorf("expected a RasterCanvas without NewCanvas, got %T", e.canvas)
+	}
+
+	e = New()
+	e.NewCanvas = func(bounds image.Rectangle) Canvas { return &spyCanvas{bounds: bounds} }
+	if img := e.Eval(strings.NewReader("Line 0 0"), "test.dbn"); img != nil {
+		t.Errorf("expected no image after a syntax error, got %v", img)
+	}
+}
+
+func TestDisplayList(t *testing.T) {
+	e := New()
+	e.WithDrawing = true
+	e.Eval(strings.NewReader("Paper 50\nrectangle 10 10 60 40\nCommand Bar X\n{\n  Line X 0 X 100\n}\nBar 5\nSet [1 1] 100"), "test.dbn")
+
+	if len(e.Errors) > 0 {
+		t.Fatalf("expected no errors, got %v", e.Errors)
+	}
+
+	expected := []string{
+		"paper 0:0-0:0",
+		"paper 1:1-1:9",
+		"line 2:1-2:22",
+		"line 2:1-2:22",
+		"line 2:1-2:22",
+		"line 2:1-2:22",
+		"line 5:3-5:17",
+		"dot 8:1-8:14",
+	}
+	actual := []string{}
+	for _, op := range e.Drawing.Operations {
+		actual = append(actual, fmt.Sprintf("%s %d:%d-%d:%d", op.Kind, op.Pos.Line, op.Pos.Column, op.End.Line, op.End.Column))
+	}
+	if fmt.Sprint(actual) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	tests := []string{
+		"Paper 50\nrectangle 10 10 60 40\nBar 5",
+		"Paper 10\nWidth 3\nPen 100 50\ntriangle 20 90 50 50 90 90\nBlend difference\nAntialias on\nLine 0 0 100 30\nWidth 1\nLine 0 10 100 60\nBlend screen\nSet [50 50] 30",
+		"Line 0 0 100 100\nSize 40 20\nBlend multiply\nPaper 30 50\nWidth 2\ncircle 20 10 8 100",
+	}
+
+	for i, test := range tests {
+		e := New()
+		e.WithDrawing = true
+		img := e.Eval(strings.NewReader("Command Bar X\n{\n  Line X 0 X 100\n}\n"+test), "test.dbn")
+
+		if len(e.Errors) > 0 {
+			t.Errorf("test %d: expected no errors, got %v", i, e.Errors)
+		}
+
+		var b bytes.Buffer
+		if err := EncodeJSON(&b, e.Drawing); err != nil {
+			t.Errorf("test %d: %s", i, err)
+		}
+		decoded, err := DecodeJSON(&b)
+		if err != nil {
+			t.Fatalf("test %d: %s", i, err)
+		}
+		if fmt.Sprint(decoded) != fmt.Sprint(e.Drawing) {
+			t.Errorf("test %d: expected %v, got %v", i, e.Drawing, decoded)
+		}
+
+		canvas := NewRasterCanvas(image.Rect(0, 0, decoded.Width, decoded.Height))
+		decoded.Replay(canvas)
+		if !bytes.Equal(imageToBytes(t, canvas.(*RasterCanvas)), imageToBytes(t, img)) {
+			t.Errorf("test %d: expected the replay to match the render", i)
+		}
+	}
+
+	errors := []struct {
+		input    string
+		expected string
+	}{
+		{`{"Operations":[{"Kind":"circle"}]}`, "unknown operation kind: circle"},
+		{`{"Operations":[{"Kind":"dot","Blend":"overlay"}]}`, "unknown blend mode: overlay"},
+		{`{"Width":`, "unexpected EOF"},
+	}
+
+	for i, test := range errors {
+		if _, err := DecodeJSON(strings.NewReader(test.input)); err == nil || err.Error() != test.expected {
+			t.Errorf("test %d: expected %s, got %v", i, test.expected, err)
+		}
+	}
+}
+
+func TestOperationKind(t *testing.T) {
+	for kind, expected := range map[OperationKind]string{OperationPaper: "paper", OperationLine: "line", OperationDot: "dot", OperationKind(9): "OperationKind(9)"} {
+		if kind.String() != expected {
+			t.Errorf("expected %s, got %s", expected, kind)
+		}
+	}
+}
+
+func TestBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"letterA 10 10",
+			"letter.png",
+		},
+	}
+
+	for i, test := range tests {
+		e := New()
+		img := e.Eval(strings.NewReader(test.input), "test.dbn")
+
+		if len(e.Errors) > 0 {
+			t.Errorf("test %d: expected no errors, got %v", i, e.Errors)
+		}
+
+		actual := imageToBytes(t, img)
+		expected := readBytes(t, "../testdata/"+test.expected)
+
+		if !bytes.Equal(actual, expected) {
+			t.Errorf("test %d: expected %v, but got %v", i, expected, actual)
+		}
+	}
+}
+
+func TestExamples(t *testing.T) {
+	var testDirectory func(string)
+	testDirectory = func(dir string) {
+		entries, err := os.ReadDir("../examples" + dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() {
+				testDirectory(dir + entry.Name() + "/")
+				continue
+			}
+
+			e := New()
+
+			file, err := os.Open("../examples" + dir + entry.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			img := e.Eval(file, entry.Name())
+
+			if len(e.Errors) > 0 {
+				t.Errorf("test %v: expected no errors, got %v", entry.Name(), e.Errors)
+			}
+
+			actual := imageToBytes(t, img)
+			expected := readBytes(t, "../testdata/examples"+dir+strings.Replace(entry.Name(), ".dbn", ".png", 1))
+
+			if !bytes.Equal(actual, expected) {
+				t.Errorf("test %v: expected %v, but got %v", entry.Name(), expected, actual)
+			}
+		}
+	}
+	testDirectory("/")
+}
+
+func TestColor(t *testing.T) {
+	tests := []struct {
+		input    string
+		mode     ColorMode
+		point    image.Point
+		expected color.RGBA
+	}{
+		{
+			"Paper 100 50 0",
+			ColorRGB,
+			image.Point{0, 0},
+			color.RGBA{255, 127, 0, 255},
+		},
+		{
+			"Paper 50",
+			ColorRGB,
+			image.Point{0, 0},
+			color.RGBA{127, 127, 127, 255},
+		},
+		{
+			"Pen 0 200 (0 - 10)\nLine 0 50 100 50",
+			ColorRGB,
+			image.Point{10, 50},
+			color.RGBA{0, 255, 0, 255},
+		},
+		{
+			"Set [10 10] 0 0 100",
+			ColorRGB,
+			image.Point{10, 90},
+			color.RGBA{0, 0, 255, 255},
+		},
+		{
+			"Paper 0 100 100",
+			ColorHSB,
+			image.Point{0, 0},
+			color.RGBA{255, 0, 0, 255},
+		},
+		{
+			"Paper 50 100 100",
+			ColorHSB,
+			image.Point{0, 0},
+			color.RGBA{0, 255, 255, 255},
+		},
+		{
+			"Paper 0 0 50",
+			ColorHSB,
+			image.Point{0, 0},
+			color.RGBA{128, 128, 128, 255},
+		},
+	}
+
+	for i, test := range tests {
+		e := New()
+		e.ColorMode = test.mode
+		img := e.Eval(strings.NewReader(test.input), "test.dbn")
+
+		if len(e.Errors) > 0 {
+			t.Errorf("test %d: expected no errors, got %v", i, e.Errors)
+		}
+
+		if img.At(test.point.X, test.point.Y) != test.expected {
+			t.Errorf("test %d: expected %v, got %v", i, test.expected, img.At(test.point.X, test.point.Y))
+		}
+	}
+
+	for h := 0; h < 100; h += 5 {
+		col := componentsToColor(ColorHSB, h, 100, 100)
+		if actual := colorToComponents(ColorHSB, col)[0]; actual < h-1 || actual > h+1 {
+			t.Errorf("expected hue %d to read back, got %d", h, actual)
+		}
+	}
+}
+
+func TestColorCopy(t *testing.T) {
+	tests := []struct {
+		input    string
+		mode     ColorMode
+		expected []int
+	}{
+		{
+			"Paper 20 40 60\nSet R [1 1 1]\nSet G [1 1 2]\nSet B [1 1 3]\nSet <Net 1> R\nSet <Net 2> G\nSet <Net 3> B",
+			ColorRGB,
+			[]int{20, 40, 60},
+		},
+		{
+			"Paper 30 100 50\nSet H [1 1 1]\nSet S [1 1 2]\nSet B [1 1 3]\nSet <Net 1> H\nSet <Net 2> S\nSet <Net 3> B",
+			ColorHSB,
+			[]int{30, 100, 50},
+		},
+		{
+			"Paper 100 100 100\nSet A [1 1]\nSet <Net 1> A",
+			ColorRGB,
+			[]int{0},
+		},
+		{
+			"Paper 50\nSet A [1 1]\nSet <Net 1> A",
+			ColorHSB,
+			[]int{51},
+		},
+	}
+
+	for i, test := range tests {
+		e := New()
+		e.ColorMode = test.mode
+		e.Eval(strings.NewReader(test.input), "test.dbn")
+
+		if len(e.Errors) > 0 {
+			t.Errorf("test %d: expected no errors, got %v", i, e.Errors)
+		}
+
+		for j, expected := range test.expected {
+			if actual, _ := e.Net.Get(j + 1); actual != expected {
+				t.Errorf("test %d: expected channel %d to be %d, got %d", i, j+1, expected, actual)
+			}
+		}
+	}
+}
+
+func TestColorErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		mode     ColorMode
+		expected string
+	}{
+		{
+			"Paper 100 0 0",
+			ColorGrayscale,
+			"test.dbn:1:7: Colors need the rgb or hsb color mode: 100 0 0",
+		},
+		{
+			"Set A [0 0 1]",
+			ColorGrayscale,
+			"test.dbn:1:12: Color channels need the rgb or hsb color mode",
+		},
+		{
+			"Set A [0 0 4]",
+			ColorRGB,
+			"test.dbn:1:12: Invalid color channel: 4",
+		},
+	}
+
+	for i, test := range tests {
+		e := New()
+		e.ColorMode = test.mode
+		e.Eval(strings.NewReader(test.input), "test.dbn")
+
+		if len(e.Errors) != 1 || e.Errors[0].String() != test.expected {
+			t.Errorf("test %d: expected %s, got %v", i, test.expected, e.Errors)
+		}
+	}
+}
+
+func TestColorMode(t *testing.T) {
+	for _, mode := range []ColorMode{ColorGrayscale, ColorRGB, ColorHSB} {
+		parsed, err := ParseColorMode(mode.String())
+		if err != nil || parsed != mode {
+			t.Errorf("expected %s, got %s %v", mode, parsed, err)
+		}
+	}
+
+	if _, err := ParseColorMode("cmyk"); err == nil || err.Error() != "unknown color mode: cmyk" {
+		t.Errorf("expected an unknown color mode error, got %v", err)
+	}
+
+	if ColorMode(9).String() != "ColorMode(9)" {
+		t.Errorf("expected ColorMode(9), got %s", ColorMode(9))
+	}
+}
+
+func TestBlend(t *testing.T) {
+	tests := []struct {
+		input    string
+		point    image.Point
+		expected color.RGBA
+	}{
+		{
+			"Set [10 10] 100 50",
+			image.Point{10, 90},
+			color.RGBA{128, 128, 128, 255},
+		},
+		{
+			"Pen 100 50\nLine 0 50 100 50",
+			image.Point{10, 50},
+			color.RGBA{128, 128, 128, 255},
+		},
+		{
+			"Paper 100\nPaper 0 150",
+			image.Point{0, 0},
+			color.RGBA{255, 255, 255, 255},
+		},
+		{
+			"Paper 50\nBlend multiply\nPaper 50",
+			image.Point{0, 0},
+			color.RGBA{63, 63, 63, 255},
+		},
+		{
+			"Paper 50\nBlend Multiply\nPaper 50",
+			image.Point{0, 0},
+			color.RGBA{63, 63, 63, 255},
+		},
+		{
+			"Paper 50\nBlend screen\nSet [1 1] 50",
+			image.Point{1, 99},
+			color.RGBA{191, 191, 191, 255},
+		},
+		{
+			"Paper 50\nBlend add\nSet [1 1] 50",
+			image.Point{1, 99},
+			color.RGBA{254, 254, 254, 255},
+		},
+		{
+			"Paper 50\nBlend difference\nSet [1 1] 0",
+			image.Point{1, 99},
+			color.RGBA{128, 128, 128, 255},
+		},
+		{
+			"Paper 50\nBlend multiply\nBlend normal\nSet [1 1] 0",
+			image.Point{1, 99},
+			color.RGBA{255, 255, 255, 255},
+		},
+		{
+			"Paper 0\nBlend multiply\nSet [1 1] 100 0 0 50",
+			image.Point{1, 99},
+			color.RGBA{255, 128, 128, 255},
+		},
+	}
+
+	for i, test := range tests {
+		e := New()
+		e.ColorMode = ColorRGB
+		img := e.Eval(strings.NewReader(test.input), "test.dbn")
+
+		if len(e.Errors) > 0 {
+			t.Errorf("test %d: expected no errors, got %v", i, e.Errors)
+		}
+
+		if img.At(test.point.X, test.point.Y) != test.expected {
+			t.Errorf("test %d: expected %v, got %v", i, test.expected, img.At(test.point.X, test.point.Y))
+		}
+	}
+
+	e := New()
+	e.Eval(strings.NewReader("Blend overlay"), "test.dbn")
+	expected := "test.dbn:1:14: Unknown Blend mode: overlay"
+	if len(e.Errors) != 1 || e.Errors[0].String() != expected {
+		t.Errorf("expected %s, got %v", expected, e.Errors)
+	}
+
+	actual := composite(color.RGBA{}, color.NRGBA{255, 0, 0, 128}, BlendMultiply)
+	if actual != (color.RGBA{128, 0, 0, 128}) {
+		t.Errorf("expected red over nothing to keep its opacity, got %v", actual)
+	}
+}
+
+func TestBlendMode(t *testing.T) {
+	for _, mode := range []BlendMode{BlendNormal, BlendMultiply, BlendScreen, BlendAdd, BlendDifference} {
+		parsed, err := ParseBlendMode(mode.String())
+		if err != nil || parsed != mode {
+			t.Errorf("expected %s, got %s %v", mode, parsed, err)
+		}
+	}
+
+	if parsed, err := ParseBlendMode("DIFFERENCE"); err != nil || parsed != BlendDifference {
+		t.Errorf("expected difference, got %s %v", parsed, err)
+	}
+
+	if BlendMode(9).String() != "BlendMode(9)" {
+		t.Errorf("expected BlendMode(9), got %s", BlendMode(9))
+	}
+}
+
+func TestAntialias(t *testing.T) {
+	tests := []struct {
+		input     string
+		antialias bool
+		point     image.Point
+		expected  color.RGBA
+	}{
+		{
+			"Line 0 50 100 51",
+			false,
+			image.Point{25, 50},
+			color.RGBA{0, 0, 0, 255},
+		},
+		{
+			"Line 0 50 100 51",
+			true,
+			image.Point{50, 50},
+			color.RGBA{127, 127, 127, 255},
+		},
+		{
+			"Antialias on\nLine 0 50 100 51",
+			false,
+			image.Point{50, 50},
+			color.RGBA{127, 127, 127, 255},
+		},
+		{
+			"Antialias off\nLine 0 50 100 51",
+			true,
+			image.Point{75, 49},
+			color.RGBA{0, 0, 0, 255},
+		},
+		{
+			"Antialias ON\nLine 0 50 100 51",
+			false,
+			image.Point{50, 50},
+			color.RGBA{127, 127, 127, 255},
+		},
+		{
+			"Antialias on\nLine 50 0 51 100",
+			false,
+			image.Point{51, 50},
+			color.RGBA{127, 127, 127, 255},
+		},
+		{
+			"Antialias on\nLine 10 10 10 10",
+			false,
+			image.Point{10, 90},
+			color.RGBA{0, 0, 0, 255},
+		},
+		{
+			"Antialias on\nPen 100 50\nLine 10 10 10 10",
+			false,
+			image.Point{10, 90},
+			color.RGBA{128, 128, 128, 255},
+		},
+	}
+
+	for i, test := range tests {
+		e := New()
+		e.Antialias = test.antialias
+		img := e.Eval(strings.NewReader(test.input), "test.dbn")
+
+		if len(e.Errors) > 0 {
+			t.Errorf("test %d: expected no errors, got %v", i, e.Errors)
+		}
+
+		if img.At(test.point.X, test.point.Y) != test.expected {
+			t.Errorf("test %d: expected %v, got %v", i, test.expected, img.At(test.point.X, test.point.Y))
+		}
+	}
+
+	e := New()
+	e.Eval(strings.NewReader("Antialias maybe"), "test.dbn")
+	expected := "test.dbn:1:16: Antialias expects on or off, got maybe"
+	if len(e.Errors) != 1 || e.Errors[0].String() != expected {
+		t.Errorf("expected %s, got %v", expected, e.Errors)
+	}
+
+	e = New()
+	img := e.Eval(strings.NewReader("Antialias on\nLine 0 0 100 100"), "test.dbn")
+	actual := imageToBytes(t, img)
+	if !bytes.Equal(actual, readBytes(t, "../testdata/diagonal.png")) {
+		t.Errorf("expected a 45 degree line to have no partial pixels")
+	}
+}
+
+func TestWidth(t *testing.T) {
+	tests := []struct {
+		input    string
+		width    int
+		point    image.Point
+		expected color.RGBA
+	}{
+		{
+			"Line 10 50 90 50",
+			1,
+			image.Point{50, 49},
+			color.RGBA{255, 255, 255, 255},
+		},
+		{
+			"Width 3\nLine 10 50 90 50",
+			1,
+			image.Point{50, 49},
+			color.RGBA{0, 0, 0, 255},
+		},
+		{
+			"Width 3\nLine 10 50 90 50",
+			1,
+			image.Point{50, 52},
+			color.RGBA{255, 255, 255, 255},
+		},
+		{
+			"Line 10 50 90 50",
+			2,
+			image.Point{50, 51},
+			color.RGBA{0, 0, 0, 255},
+		},
+		{
+			"Line 10 50 90 50",
+			2,
+			image.Point{50, 49},
+			color.RGBA{255, 255, 255, 255},
+		},
+		{
+			"Width 5\nLine 10 50 90 50",
+			1,
+			image.Point{8, 50},
+			color.RGBA{0, 0, 0, 255},
+		},
+		{
+			"Width 5\nLine 10 50 90 50",
+			1,
+			image.Point{7, 50},
+			color.RGBA{255, 255, 255, 255},
+		},
+		{
+			"Width 5\nSet [50 50] 100",
+			1,
+			image.Point{50, 48},
+			color.RGBA{255, 255, 255, 255},
+		},
+		{
+			"Width 5\ncircle 50 50 0 100",
+			1,
+			image.Point{50, 48},
+			color.RGBA{0, 0, 0, 255},
+		},
+		{
+			"Width 5\nSet [50 50] 100",
+			1,
+			image.Point{52, 48},
+			color.RGBA{255, 255, 255, 255},
+		},
+		{
+			"Width 3\nAntialias on\ncircle 50 50 0 100",
+			1,
+			image.Point{49, 48},
+			color.RGBA{44, 44, 44, 255},
+		},
+		{
+			"Width 3\nrectangle 10 10 90 90",
+			1,
+			image.Point{11, 50},
+			color.RGBA{0, 0, 0, 255},
+		},
+		{
+			"Width 3\ncircle 50 50 20 100",
+			1,
+			image.Point{31, 50},
+			color.RGBA{0, 0, 0, 255},
+		},
+		{
+			"Width 3\nWidth 0\nLine 10 50 90 50",
+			1,
+			image.Point{50, 49},
+			color.RGBA{0, 0, 0, 255},
+		},
+	}
+
+	for i, test := range tests {
+		e := New()
+		e.PenWidth = test.width
+		img := e.Eval(strings.NewReader(test.input), "test.dbn")
+
+		if img.At(test.point.X, test.point.Y) != test.expected {
+			t.Errorf("test %d: expected %v, got %v", i, test.expected, img.At(test.point.X, test.point.Y))
+		}
+	}
+
+	e := New()
+	e.Eval(strings.NewReader("Width 0"), "test.dbn")
+	expected := "test.dbn:1:6: Invalid Width: 0"
+	if len(e.Errors) != 1 || e.Errors[0].String() != expected {
+		t.Errorf("expected %s, got %v", expected, e.Errors)
+	}
+}
+
+func TestFaithful(t *testing.T) {
+	tests := []struct {
+		input  string
+		size   image.Point
+		points []image.Point
+	}{
+		{
+			"Line 0 0 100 100",
+			image.Point{101, 101},
+			[]image.Point{{0, 100}, {50, 50}, {100, 0}},
+		},
+		{
+			"Set [0 0] 100\nSet [100 100] 100",
+			image.Point{101, 101},
+			[]image.Point{{0, 100}, {100, 0}},
+		},
+		{
+			"Set [100 0] 100\nSet A [100 0]\nSet [0 A] 100",
+			image.Point{101, 101},
+			[]image.Point{{100, 100}, {0, 0}},
+		},
+		{
+			"Size 20 10\nPaper 150\nSet [20 10] 200",
+			image.Point{21, 11},
+			[]image.Point{{0, 0}, {20, 10}},
+		},
+	}
+
+	black := color.RGBA{0, 0, 0, 255}
+
+	for i, test := range tests {
+		e := New()
+		e.Faithful = true
+		img := e.Eval(strings.NewReader(test.input), "test.dbn")
+
+		if len(e.Errors) > 0 {
+			t.Errorf("test %d: expected no errors, got %v", i, e.Errors)
+		}
+
+		if img.Bounds().Size() != test.size {
+			t.Errorf("test %d: expected size %v, got %v", i, test.size, img.Bounds().Size())
+		}
+
+		for _, point := range test.points {
+			if img.At(point.X, point.Y) != black {
+				t.Errorf("test %d: expected black at %v, got %v", i, point, img.At(point.X, point.Y))
+			}
+		}
+	}
+
+	e := New()
+	e.Faithful = true
+	img := e.Eval(strings.NewReader("Paper (0 - 50)"), "test.dbn")
+	white := color.RGBA{255, 255, 255, 255}
+	if img.At(0, 0) != white {
+		t.Errorf("expected negative paper to be white, got %v", img.At(0, 0))
+	}
+}
+
+// TestFaithfulSnapshots compares the MIT Press examples with snapshots that
+// were rendered by faithful mode itself, not by the original DBN. They catch
+// regressions but do not show that the output matches the original.
+func TestFaithfulSnapshots(t *testing.T) {
+	entries, err := os.ReadDir("../examples/mitpress")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range entries {
+		e := New()
+		e.Faithful = true
+
+		file, err := os.Open("../examples/mitpress/" + entry.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		img := e.Eval(file, entry.Name())
+		file.Close()
+
+		if len(e.Errors) > 0 {
+			t.Errorf("test %v: expected no errors, got %v", entry.Name(), e.Errors)
+		}
+
+		actual := imageToBytes(t, img)
+		expected := readBytes(t, "../testdata/faithful/snapshots/"+strings.Replace(entry.Name(), ".dbn", ".png", 1))
+
+		if !bytes.Equal(actual, expected) {
+			t.Errorf("test %v: expected %v, but got %v", entry.Name(), expected, actual)
+		}
+	}
+}
+
+func imageToBytes(t *testing.T, img image.Image) []byte {
+	buf := new(bytes.Buffer)
+	err := png.Encode(buf, img)
+	if err != nil {
+		t.Fatalf("failed to encode image: %s", err)
+	}
+
+	debugFile, err := os.Create("../tmp/debug.png")
+	if err != nil {
+		t.Fatalf("failed to create debug file: %s", err)
+	}
+	defer debugFile.Close()
+
+	if err := png.Encode(debugFile, img); err != nil {
+		t.Fatalf("failed to encode debug image: %s", err)
+	}
+
+	return buf.Bytes()
+}
+
+func gifToBytes(t *testing.T, g *gif.GIF) []byte {
+	buf := new(bytes.Buffer)
+	err := gif.EncodeAll(buf, g)
+	if err != nil {
+		t.Fatalf("failed to encode image: %s", err)
+	}
+
+	debugFile, err := os.Create("../tmp/debug.gif")
+	if err != nil {
+		t.Fatalf("failed to create debug file: %s", err)
+	}
+	defer debugFile.Close()
+
+	if err := gif.EncodeAll(debugFile, g); err != nil {
+		t.Fatalf("failed to encode debug gif: %s", err)
+	}
+
+	return buf.Bytes()
+}
+
+func readBytes(t *testing.T, path string) []byte {
+	t.Helper()
 
 	file, err := os.Open(path)
 	if err != nil {
@@ -832,6 +2761,19 @@ func readBytes(t *testing.T, path string) []byte {
 	}
 	defer file.Close()
 
+	if strings.HasSuffix(path, ".png") {
+		// Re-encode so that comparisons don't depend on the encoder version.
+		img, err := png.Decode(file)
+		if err != nil {
+			t.Fatalf("failed to decode file: %s", err)
+		}
+		buf := new(bytes.Buffer)
+		if err := png.Encode(buf, img); err != nil {
+			t.Fatalf("failed to encode image: %s", err)
+		}
+		return buf.Bytes()
+	}
+
 	bytes, err := io.ReadAll(file)
 	if err != nil {
 		t.Fatalf("failed to read file: %s", err)
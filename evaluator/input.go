@@ -0,0 +1,129 @@
+package evaluator
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const KEY_COUNT = 26
+
+// MouseInput supplies the values of <Mouse 1> (x), <Mouse 2> (y) and
+// <Mouse 3> (button) for each frame.
+type MouseInput interface {
+	Mouse(frame int) (x, y, button int)
+}
+
+// MouseTimeline is a MouseInput read from a text file where each line is
+// "frame x y button". A line stays in effect until the next frame listed.
+type MouseTimeline struct {
+	entries []timelineEntry
+}
+
+func ReadMouseTimeline(r io.Reader) (*MouseTimeline, error) {
+	entries, err := readTimeline(r, 3, 3)
+	if err != nil {
+		return nil, err
+	}
+	return &MouseTimeline{entries: entries}, nil
+}
+
+func (m *MouseTimeline) Mouse(frame int) (int, int, int) {
+	values := lookupTimeline(m.entries, frame)
+	if values == nil {
+		return 0, 0, 0
+	}
+	return values[0], values[1], values[2]
+}
+
+// KeyInput reports whether DBN key n (1 to 26 for A to Z) is down on a
+// frame.
+type KeyInput interface {
+	Key(frame int, n int) bool
+}
+
+// KeyTimeline is a KeyInput read from a recorded keystroke file where each
+// line is "frame key..." listing the keys held down from that frame on.
+// A line with only a frame releases every key.
+type KeyTimeline struct {
+	entries []timelineEntry
+}
+
+func ReadKeyTimeline(r io.Reader) (*KeyTimeline, error) {
+	entries, err := readTimeline(r, 0, KEY_COUNT)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyTimeline{entries: entries}, nil
+}
+
+func (k *KeyTimeline) Key(frame int, n int) bool {
+	for _, key := range lookupTimeline(k.entries, frame) {
+		if key == n {
+			return true
+		}
+	}
+	return false
+}
+
+type timelineEntry struct {
+	frame  int
+	values []int
+}
+
+func readTimeline(r io.Reader, min int, max int) ([]timelineEntry, error) {
+	entries := []timelineEntry{}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if i := strings.Index(text, "//"); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields)-1 < min || len(fields)-1 > max {
+			return nil, fmt.Errorf("line %d: expected a frame and %s, got %d fields", line, valuesCount(min, max), len(fields))
+		}
+		numbers := make([]int, len(fields))
+		for i, field := range fields {
+			num, err := strconv.Atoi(field)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid number: %s", line, field)
+			}
+			numbers[i] = num
+		}
+		entries = append(entries, timelineEntry{frame: numbers[0], values: numbers[1:]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].frame < entries[j].frame
+	})
+	return entries, nil
+}
+
+func valuesCount(min int, max int) string {
+	if min == max {
+		return fmt.Sprintf("%d values", min)
+	}
+	return fmt.Sprintf("%d to %d values", min, max)
+}
+
+func lookupTimeline(entries []timelineEntry, frame int) []int {
+	var values []int
+	for _, entry := range entries {
+		if entry.frame > frame {
+			break
+		}
+		values = entry.values
+	}
+	return values
+}
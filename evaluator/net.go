@@ -0,0 +1,120 @@
+package evaluator
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+const NET_LENGTH = 1000
+
+// NetProvider stores the numbers read by <Net n> and written by
+// Set <Net n> v. Slots start at 1 like the original DBN.
+type NetProvider interface {
+	Get(slot int) (int, error)
+	Set(slot int, value int) error
+}
+
+// MemoryNet keeps the slots in memory, so they are only shared between
+// sketches evaluated in the same process.
+type MemoryNet struct {
+	mu    sync.RWMutex
+	slots map[int]int
+}
+
+func NewMemoryNet() *MemoryNet {
+	return &MemoryNet{slots: map[int]int{}}
+}
+
+func (m *MemoryNet) Get(slot int) (int, error) {
+	if err := checkNetSlot(slot); err != nil {
+		return 0, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.slots[slot], nil
+}
+
+func (m *MemoryNet) Set(slot int, value int) error {
+	if err := checkNetSlot(slot); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.slots[slot] = value
+	return nil
+}
+
+// UDPNet keeps the slots in memory and sends every write to its peers as a
+// "slot value" datagram, so that several dbngo processes can share them.
+type UDPNet struct {
+	MemoryNet
+	conn  *net.UDPConn
+	peers []*net.UDPAddr
+}
+
+func ListenUDPNet(address string) (*UDPNet, error) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	n := &UDPNet{MemoryNet: MemoryNet{slots: map[int]int{}}, conn: conn}
+	go n.receive()
+	return n, nil
+}
+
+func (n *UDPNet) Addr() string {
+	return n.conn.LocalAddr().String()
+}
+
+func (n *UDPNet) AddPeer(address string) error {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return err
+	}
+	n.peers = append(n.peers, addr)
+	return nil
+}
+
+func (n *UDPNet) Set(slot int, value int) error {
+	if err := n.MemoryNet.Set(slot, value); err != nil {
+		return err
+	}
+	message := []byte(fmt.Sprintf("%d %d", slot, value))
+	for _, peer := range n.peers {
+		if _, err := n.conn.WriteToUDP(message, peer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *UDPNet) Close() error {
+	return n.conn.Close()
+}
+
+func (n *UDPNet) receive() {
+	buf := make([]byte, 64)
+	for {
+		size, _, err := n.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		var slot, value int
+		if _, err := fmt.Sscanf(string(buf[:size]), "%d %d", &slot, &value); err != nil {
+			continue
+		}
+		n.MemoryNet.Set(slot, value)
+	}
+}
+
+func checkNetSlot(slot int) error {
+	if slot < 1 || slot > NET_LENGTH {
+		return fmt.Errorf("Net slot out of range: %d", slot)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package evaluator
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// PageSize is the physical size of a PDF page in points, 72 to the inch.
+type PageSize struct {
+	Width  float64
+	Height float64
+}
+
+const pointsPerMillimetre = 72 / 25.4
+
+var pageSizes = map[string]PageSize{
+	"a3":     {297 * pointsPerMillimetre, 420 * pointsPerMillimetre},
+	"a4":     {210 * pointsPerMillimetre, 297 * pointsPerMillimetre},
+	"a5":     {148 * pointsPerMillimetre, 210 * pointsPerMillimetre},
+	"letter": {8.5 * 72, 11 * 72},
+	"legal":  {8.5 * 72, 14 * 72},
+}
+
+// ParsePageSize reads a page size name such as a4 or letter, or a custom
+// size such as 300x300mm or 8x10in.
+func ParsePageSize(s string) (PageSize, error) {
+	if size, ok := pageSizes[strings.ToLower(s)]; ok {
+		return size, nil
+	}
+
+	var width, height float64
+	var unit string
+	if _, err := fmt.Sscanf(s, "%gx%g%s", &width, &height, &unit); err != nil || width <= 0 || height <= 0 {
+		return PageSize{}, fmt.Errorf("invalid page size: %s", s)
+	}
+	switch unit {
+	case "mm":
+		return PageSize{width * pointsPerMillimetre, height * pointsPerMillimetre}, nil
+	case "in":
+		return PageSize{width * 72, height * 72}, nil
+	}
+	return PageSize{}, fmt.Errorf("invalid page size unit: %s", unit)
+}
+
+// pdfBlendModes are the PDF blend modes for each Blend mode. PDF has no
+// additive mode, so Add uses Screen, which also only lightens.
+var pdfBlendModes = map[BlendMode]string{
+	BlendNormal:     "Normal",
+	BlendMultiply:   "Multiply",
+	BlendScreen:     "Screen",
+	BlendAdd:        "Screen",
+	BlendDifference: "Difference",
+}
+
+// pdfState is the opacity and blend mode of an ExtGState resource.
+type pdfState struct {
+	alpha uint8
+	mode  BlendMode
+}
+
+// EncodePDF writes d as a single page PDF of the given size. The paper is
+// scaled to fit the page and centered, and every operation is vector
+// content in the same geometry as EncodeSVG. d is usually Evaluator.Drawing,
+// which Eval only records when WithDrawing is set.
+func EncodePDF(w io.Writer, d *Drawing, page PageSize) error {
+	if err := checkDrawing(d); err != nil {
+		return err
+	}
+	scale := math.Min(page.Width/float64(d.Width), page.Height/float64(d.Height))
+	left := (page.Width - scale*float64(d.Width)) / 2
+	top := (page.Height + scale*float64(d.Height)) / 2
+
+	var content bytes.Buffer
+	var states []pdfState
+	current := -1
+	setState := func(op Operation) {
+		s := pdfState{op.Color.A, op.Blend}
+		index := len(states)
+		for i, existing := range states {
+			if existing == s {
+				index = i
+			}
+		}
+		if index == len(states) {
+			states = append(states, s)
+		}
+		if index != current {
+			fmt.Fprintf(&content, "/GS%d gs\n", index)
+			current = index
+		}
+	}
+
+	// Flip y so that operations keep their image coordinates, and clip to
+	// the paper.
+	fmt.Fprintf(&content, "%s 0 0 %s %s %s cm\n", pdfNumber(scale), pdfNumber(-scale), pdfNumber(left), pdfNumber(top))
+	fmt.Fprintf(&content, "0 0 %d %d re W n\n", d.Width, d.Height)
+	for _, op := range d.visible() {
+		setState(op)
+		switch op.Kind {
+		case OperationPaper:
+			fmt.Fprintf(&content, "%s rg\n0 0 %d %d re f\n", pdfColor(op.Color), d.Width, d.Height)
+		case OperationLine:
+			width, offset := strokeGeometry(op.Width)
+			linecap := 1
+			if width == 1 {
+				linecap = 2
+			}
+			fmt.Fprintf(&content, "%s RG\n%d w %d J\n%s %s m %s %s l S\n", pdfColor(op.Color), width, linecap,
+				pdfNumber(float64(op.X1)+offset), pdfNumber(float64(op.Y1)+offset),
+				pdfNumber(float64(op.X2)+offset), pdfNumber(float64(op.Y2)+offset))
+		case OperationDot:
+			width, offset := strokeGeometry(op.Width)
+			fmt.Fprintf(&content, "%s rg\n", pdfColor(op.Color))
+			if width == 1 {
+				fmt.Fprintf(&content, "%d %d 1 1 re f\n", op.X1, op.Y1)
+			} else {
+				pdfCircle(&content, float64(op.X1)+offset, float64(op.Y1)+offset, float64(width)/2)
+			}
+		}
+	}
+
+	var resources strings.Builder
+	for i, s := range states {
+		fmt.Fprintf(&resources, "/GS%d << /Type /ExtGState /CA %s /ca %s /BM /%s >> ", i, pdfNumber(float64(s.alpha)/255), pdfNumber(float64(s.alpha)/255), pdfBlendModes[s.mode])
+	}
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << /ExtGState << %s>> >> /Contents 4 0 R >>", pdfNumber(page.Width), pdfNumber(page.Height), resources.String()),
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+	}
+
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// pdfCircle fills a circle made of four Bezier curves.
+func pdfCircle(b *bytes.Buffer, cx float64, cy float64, r float64) {
+	k := r * 0.5523
+	fmt.Fprintf(b, "%s %s m\n", pdfNumber(cx+r), pdfNumber(cy))
+	fmt.Fprintf(b, "%s %s %s %s %s %s c\n", pdfNumber(cx+r), pdfNumber(cy+k), pdfNumber(cx+k), pdfNumber(cy+r), pdfNumber(cx), pdfNumber(cy+r))
+	fmt.Fprintf(b, "%s %s %s %s %s %s c\n", pdfNumber(cx-k), pdfNumber(cy+r), pdfNumber(cx-r), pdfNumber(cy+k), pdfNumber(cx-r), pdfNumber(cy))
+	fmt.Fprintf(b, "%s %s %s %s %s %s c\n", pdfNumber(cx-r), pdfNumber(cy-k), pdfNumber(cx-k), pdfNumber(cy-r), pdfNumber(cx), pdfNumber(cy-r))
+	fmt.Fprintf(b, "%s %s %s %s %s %s c\n", pdfNumber(cx+k), pdfNumber(cy-r), pdfNumber(cx+r), pdfNumber(cy-k), pdfNumber(cx+r), pdfNumber(cy))
+	b.WriteString("f\n")
+}
+
+func pdfColor(c color.NRGBA) string {
+	return fmt.Sprintf("%s %s %s", pdfNumber(float64(c.R)/255), pdfNumber(float64(c.G)/255), pdfNumber(float64(c.B)/255))
+}
+
+func pdfNumber(n float64) string {
+	return strconv.FormatFloat(math.Round(n*1000)/1000, 'f', -1, 64)
+}
@@ -0,0 +1,260 @@
+package evaluator
+
+import (
+	"bufio"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// PlotFormat is the language EncodePlot writes for a pen plotter.
+type PlotFormat int
+
+const (
+	PlotHPGL PlotFormat = iota
+	PlotGCode
+)
+
+var plotFormatNames = []string{"hpgl", "gcode"}
+
+func (f PlotFormat) String() string {
+	if f < 0 || int(f) >= len(plotFormatNames) {
+		return fmt.Sprintf("PlotFormat(%d)", int(f))
+	}
+	return plotFormatNames[f]
+}
+
+func ParsePlotFormat(s string) (PlotFormat, error) {
+	for i, name := range plotFormatNames {
+		if name == s {
+			return PlotFormat(i), nil
+		}
+	}
+	return PlotHPGL, fmt.Errorf("unknown plot format: %s", s)
+}
+
+// PlotOptions configures EncodePlot. The bed is in millimetres. Pens is the
+// number of pens gray levels are quantised to, or 0 for one pen per gray
+// level.
+type PlotOptions struct {
+	Format    PlotFormat
+	BedWidth  float64
+	BedHeight float64
+	Pens      int
+}
+
+// plotStroke is a line from (x1, y1) to (x2, y2) in millimetres with y
+// growing upwards from the bottom left of the bed. Dots start and end at the
+// same point.
+type plotStroke struct {
+	x1 float64
+	y1 float64
+	x2 float64
+	y2 float64
+}
+
+// plotter writes the commands of one plotter language. move travels to a
+// point with the pen up and lowers it there, and draw moves with the pen
+// down.
+type plotter interface {
+	start()
+	pen(number int, level int)
+	move(x float64, y float64)
+	draw(x float64, y float64)
+	end()
+}
+
+// EncodePlot writes the lines and dots of d for a pen plotter. Strokes are
+// grouped into one pen per gray level, darkest first, and each pen's strokes
+// are ordered nearest first to keep pen up travel short. The paper is
+// scaled to fit the bed. White strokes are left out because the paper is
+// already white, and Paper and Width are ignored because a plotter can only
+// draw with the pens it holds. d is usually Evaluator.Drawing, which Eval
+// only records when WithDrawing is set.
+func EncodePlot(w io.Writer, d *Drawing, options PlotOptions) error {
+	if err := checkDrawing(d); err != nil {
+		return err
+	}
+	scale := math.Min(options.BedWidth/float64(d.Width), options.BedHeight/float64(d.Height))
+	width, height := float64(d.Width)*scale, float64(d.Height)*scale
+
+	strokes := map[int][]plotStroke{}
+	for _, op := range d.visible() {
+		if op.Kind == OperationPaper {
+			continue
+		}
+		level := plotLevel(op.Color, options.Pens)
+		if level == 0 {
+			continue
+		}
+		s, ok := clipStroke(plotStroke{
+			(float64(op.X1) + 0.5) * scale,
+			(float64(d.Height) - float64(op.Y1) - 0.5) * scale,
+			(float64(op.X2) + 0.5) * scale,
+			(float64(d.Height) - float64(op.Y2) - 0.5) * scale,
+		}, width, height)
+		if ok {
+			strokes[level] = append(strokes[level], s)
+		}
+	}
+
+	levels := make([]int, 0, len(strokes))
+	for level := range strokes {
+		levels = append(levels, level)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(levels)))
+
+	b := bufio.NewWriter(w)
+	var p plotter = &hpglPlotter{b}
+	if options.Format == PlotGCode {
+		p = &gcodePlotter{b}
+	}
+
+	p.start()
+	x, y := 0.0, 0.0
+	for i, level := range levels {
+		p.pen(i+1, level)
+		down := false
+		for _, s := range orderStrokes(strokes[level], x, y) {
+			if !down || s.x1 != x || s.y1 != y {
+				p.move(s.x1, s.y1)
+			}
+			p.draw(s.x2, s.y2)
+			x, y, down = s.x2, s.y2, true
+		}
+	}
+	p.end()
+	return b.Flush()
+}
+
+// plotLevel is the DBN gray level of c, lightened by its opacity and
+// quantised to the given number of pens.
+func plotLevel(c color.NRGBA, pens int) int {
+	gray := color.GrayModel.Convert(color.RGBA{c.R, c.G, c.B, 255}).(color.Gray)
+	level := int(math.Round((255 - float64(gray.Y)) * 100 / 255 * float64(c.A) / 255))
+	if pens < 1 || level == 0 {
+		return level
+	}
+	return (level*pens + 99) / 100 * 100 / pens
+}
+
+// clipStroke clips s to the paper from (0, 0) to (width, height) with the
+// Liang-Barsky algorithm, so that the pen never leaves the paper.
+func clipStroke(s plotStroke, width float64, height float64) (plotStroke, bool) {
+	dx, dy := s.x2-s.x1, s.y2-s.y1
+	t0, t1 := 0.0, 1.0
+	for _, edge := range [][2]float64{{-dx, s.x1}, {dx, width - s.x1}, {-dy, s.y1}, {dy, height - s.y1}} {
+		p, q := edge[0], edge[1]
+		if p == 0 {
+			if q < 0 {
+				return s, false
+			}
+			continue
+		}
+		t := q / p
+		if p < 0 && t > t0 {
+			t0 = t
+		} else if p > 0 && t < t1 {
+			t1 = t
+		}
+	}
+	if t0 > t1 {
+		return s, false
+	}
+	return plotStroke{s.x1 + t0*dx, s.y1 + t0*dy, s.x1 + t1*dx, s.y1 + t1*dy}, true
+}
+
+// orderStrokes orders strokes greedily so that each one starts at the
+// nearest end of the remaining strokes, reversing strokes where needed.
+func orderStrokes(strokes []plotStroke, x float64, y float64) []plotStroke {
+	remaining := append([]plotStroke(nil), strokes...)
+	ordered := make([]plotStroke, 0, len(strokes))
+	for len(remaining) > 0 {
+		best, reverse, distance := 0, false, math.Inf(1)
+		for i, s := range remaining {
+			if d := math.Hypot(s.x1-x, s.y1-y); d < distance {
+				best, reverse, distance = i, false, d
+			}
+			if d := math.Hypot(s.x2-x, s.y2-y); d < distance {
+				best, reverse, distance = i, true, d
+			}
+		}
+		s := remaining[best]
+		if reverse {
+			s = plotStroke{s.x2, s.y2, s.x1, s.y1}
+		}
+		ordered = append(ordered, s)
+		remaining = append(remaining[:best], remaining[best+1:]...)
+		x, y = s.x2, s.y2
+	}
+	return ordered
+}
+
+// hpglPlotter writes HP-GL in plotter units of 0.025mm.
+type hpglPlotter struct {
+	w io.Writer
+}
+
+func (p *hpglPlotter) start() {
+	fmt.Fprintln(p.w, "IN;")
+}
+
+func (p *hpglPlotter) pen(number int, level int) {
+	fmt.Fprintf(p.w, "SP%d;\n", number)
+}
+
+func (p *hpglPlotter) move(x float64, y float64) {
+	fmt.Fprintf(p.w, "PU%d,%d;\n", hpglUnits(x), hpglUnits(y))
+}
+
+func (p *hpglPlotter) draw(x float64, y float64) {
+	fmt.Fprintf(p.w, "PD%d,%d;\n", hpglUnits(x), hpglUnits(y))
+}
+
+func (p *hpglPlotter) end() {
+	fmt.Fprintln(p.w, "PU;")
+	fmt.Fprintln(p.w, "SP0;")
+}
+
+func hpglUnits(mm float64) int {
+	return int(math.Round(mm * 40))
+}
+
+// gcodePlotter writes G-code in millimetres that lifts the pen with Z and
+// pauses with M0 for pen changes.
+type gcodePlotter struct {
+	w io.Writer
+}
+
+func (p *gcodePlotter) start() {
+	fmt.Fprintln(p.w, "G21")
+	fmt.Fprintln(p.w, "G90")
+}
+
+func (p *gcodePlotter) pen(number int, level int) {
+	fmt.Fprintln(p.w, "G0 Z5")
+	fmt.Fprintf(p.w, "M0 (pen %d, gray %d)\n", number, level)
+}
+
+func (p *gcodePlotter) move(x float64, y float64) {
+	fmt.Fprintln(p.w, "G0 Z5")
+	fmt.Fprintf(p.w, "G0 X%s Y%s\n", gcodeNumber(x), gcodeNumber(y))
+	fmt.Fprintln(p.w, "G1 Z0")
+}
+
+func (p *gcodePlotter) draw(x float64, y float64) {
+	fmt.Fprintf(p.w, "G1 X%s Y%s\n", gcodeNumber(x), gcodeNumber(y))
+}
+
+func (p *gcodePlotter) end() {
+	fmt.Fprintln(p.w, "G0 Z5")
+	fmt.Fprintln(p.w, "G0 X0 Y0")
+	fmt.Fprintln(p.w, "M2")
+}
+
+func gcodeNumber(mm float64) string {
+	return strconv.FormatFloat(math.Round(mm*1000)/1000, 'f', -1, 64)
+}
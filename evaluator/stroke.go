@@ -0,0 +1,72 @@
+package evaluator
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// drawThickLine draws a line of the given width with round caps, so that
+// joined lines such as the sides of a rectangle meet in round joins. A line
+// with the same start and end is a round dot.
+//
+// Every pixel whose center is within width/2 of the line is drawn. Lines of
+// an even width run between pixels instead of through their centers so
+// that they are exactly width pixels across.
+func drawThickLine(img draw.Image, x0 int, y0 int, x1 int, y1 int, width int, col color.Color, antialias bool) {
+	c := color.NRGBAModel.Convert(col).(color.NRGBA)
+	radius := float64(width) / 2
+
+	offset := 0.5
+	if width%2 == 0 {
+		offset = 1
+	}
+	ax, ay := float64(x0)+offset, float64(y0)+offset
+	bx, by := float64(x1)+offset, float64(y1)+offset
+
+	reach := int(math.Ceil(radius)) + 1
+	bounds := image.Rect(minInt(x0, x1)-reach, minInt(y0, y1)-reach, maxInt(x0, x1)+reach+1, maxInt(y0, y1)+reach+1).Intersect(img.Bounds())
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			distance := distanceToSegment(float64(x)+0.5, float64(y)+0.5, ax, ay, bx, by)
+
+			coverage := 0.0
+			if antialias {
+				coverage = math.Max(0, math.Min(1, radius+0.5-distance))
+			} else if distance <= radius {
+				coverage = 1
+			}
+
+			alpha := uint8(math.Round(float64(c.A) * coverage))
+			if alpha == 0 {
+				continue
+			}
+			img.Set(x, y, color.NRGBA{c.R, c.G, c.B, alpha})
+		}
+	}
+}
+
+func distanceToSegment(px float64, py float64, ax float64, ay float64, bx float64, by float64) float64 {
+	dx, dy := bx-ax, by-ay
+	t := 0.0
+	if length := dx*dx + dy*dy; length > 0 {
+		t = math.Max(0, math.Min(1, ((px-ax)*dx+(py-ay)*dy)/length))
+	}
+	return math.Hypot(px-(ax+t*dx), py-(ay+t*dy))
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,59 @@
+package evaluator
+
+import (
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// drawWuLine draws an antialiased line with Xiaolin Wu's algorithm. Each
+// pixel gets the opacity of col scaled by how much of it the line covers, so
+// the line composites onto img.
+func drawWuLine(img draw.Image, x0 int, y0 int, x1 int, y1 int, col color.Color) {
+	c := color.NRGBAModel.Convert(col).(color.NRGBA)
+
+	steep := abs(y1-y0) > abs(x1-x0)
+	if steep {
+		x0, y0 = y0, x0
+		x1, y1 = y1, x1
+	}
+	if x0 > x1 {
+		x0, x1 = x1, x0
+		y0, y1 = y1, y0
+	}
+
+	plot := func(x int, y int, coverage float64) {
+		alpha := uint8(math.Round(float64(c.A) * coverage))
+		if alpha == 0 {
+			return
+		}
+		if steep {
+			x, y = y, x
+		}
+		img.Set(x, y, color.NRGBA{c.R, c.G, c.B, alpha})
+	}
+
+	// Endpoints sit on pixel centers, so they are fully covered.
+	plot(x0, y0, 1)
+	if x0 == x1 {
+		return
+	}
+	plot(x1, y1, 1)
+
+	gradient := float64(y1-y0) / float64(x1-x0)
+	y := float64(y0) + gradient
+	for x := x0 + 1; x < x1; x++ {
+		base := math.Floor(y)
+		fraction := y - base
+		plot(x, int(base), 1-fraction)
+		plot(x, int(base)+1, fraction)
+		y += gradient
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -2,11 +2,14 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"image/gif"
 	"image/png"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/tnantoka/dbngo/evaluator"
 )
@@ -14,19 +17,79 @@ import (
 var input string
 var outputPNG string
 var outputGIF string
+var outputSVG string
+var outputPDF string
+var pageSize string
+var outputPlot string
+var outputOps string
+var plotFormat string
+var bed string
+var pens int
 var scale int
+var size string
+var width int
+var height int
+var foreverFrames int
+var inputMouse string
+var inputKey string
+var clockTime string
+var clockStep time.Duration
+var netListen string
+var netPeers string
+var legacyRepeat bool
+var faithful bool
+var colorMode string
+var antialias bool
+var penWidth int
 
 func parseFlags() {
 	flag.StringVar(&input, "i", "", "input file")
 	flag.StringVar(&outputPNG, "p", "dbngo.png", "output png file")
 	flag.StringVar(&outputGIF, "g", "", "output gif file")
+	flag.StringVar(&outputSVG, "svg", "", "output svg file")
+	flag.StringVar(&outputPDF, "pdf", "", "output pdf file")
+	flag.StringVar(&pageSize, "page", "a4", "pdf page size: a3, a4, a5, letter, legal or WxH in mm or in")
+	flag.StringVar(&outputPlot, "plot", "", "output pen plotter file")
+	flag.StringVar(&outputOps, "ops", "", "output json file of drawing operations")
+	flag.StringVar(&plotFormat, "plot-format", evaluator.PlotHPGL.String(), "pen plotter format: hpgl or gcode")
+	flag.StringVar(&bed, "bed", "297x210", "pen plotter bed size as WxH in mm")
+	flag.IntVar(&pens, "pens", 0, "number of pens to quantise gray levels to, 0 for one per gray level")
 	flag.IntVar(&scale, "s", 1, "scale")
+	flag.StringVar(&size, "size", fmt.Sprintf("%dx%d", evaluator.DEFAULT_LENGTH, evaluator.DEFAULT_LENGTH), "canvas size as WxH")
+	flag.IntVar(&foreverFrames, "frames", evaluator.DEFAULT_FOREVER_FRAMES, "frames rendered by Forever")
+	flag.StringVar(&inputMouse, "mouse", "", "mouse timeline file")
+	flag.StringVar(&inputKey, "key", "", "recorded keystroke file")
+	flag.StringVar(&clockTime, "time", "", "fixed time as hh:mm:ss")
+	flag.DurationVar(&clockStep, "time-step", 0, "time to advance per frame")
+	flag.StringVar(&netListen, "net-listen", "", "udp address to share Net slots on")
+	flag.StringVar(&netPeers, "net-peers", "", "comma separated udp addresses of peers")
+	flag.StringVar(&colorMode, "color", evaluator.ColorGrayscale.String(), "color mode: grayscale, rgb or hsb")
+	flag.IntVar(&penWidth, "pen-width", 1, "pen width in pixels")
+	flag.BoolVar(&antialias, "antialias", false, "draw antialiased lines")
+	flag.BoolVar(&faithful, "faithful", false, "address 0 to width and height inclusive like the original DBN paper")
+	flag.BoolVar(&legacyRepeat, "legacy-repeat", false, "count Repeat up only and evaluate its end every iteration")
 
 	flag.Parse()
 
 	if scale < 1 {
 		log.Fatal("scale must be 1 or more")
 	}
+
+	if _, err := fmt.Sscanf(size, "%dx%d", &width, &height); err != nil {
+		log.Fatalf("size must be WxH: %s", err)
+	}
+
+	if width < 1 || height < 1 || width > evaluator.MAX_LENGTH || height > evaluator.MAX_LENGTH {
+		log.Fatalf("size must be between 1x1 and %dx%d", evaluator.MAX_LENGTH, evaluator.MAX_LENGTH)
+	}
+
+	if penWidth < 1 {
+		log.Fatal("pen width must be 1 or more")
+	}
+
+	if foreverFrames < 0 {
+		log.Fatal("frames must be 0 or more")
+	}
 }
 
 func main() {
@@ -46,8 +109,74 @@ func main() {
 
 	e := evaluator.New()
 	e.Scale = scale
+	e.Width = width
+	e.Height = height
 	e.Directory = filepath.Dir(filepath.Clean(input))
 	e.WithGIF = outputGIF != ""
+	e.WithDrawing = outputSVG != "" || outputPDF != "" || outputPlot != "" || outputOps != ""
+	e.ForeverFrames = foreverFrames
+	e.LegacyRepeat = legacyRepeat
+	e.Faithful = faithful
+	e.Antialias = antialias
+	e.PenWidth = penWidth
+	e.ColorMode, err = evaluator.ParseColorMode(colorMode)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if inputMouse != "" {
+		file, err := os.Open(inputMouse)
+		if err != nil {
+			log.Fatalf("failed opening mouse timeline file: %s", err)
+		}
+		defer file.Close()
+		timeline, err := evaluator.ReadMouseTimeline(file)
+		if err != nil {
+			log.Fatalf("failed reading mouse timeline file: %s", err)
+		}
+		e.Mouse = timeline
+	}
+
+	if inputKey != "" {
+		file, err := os.Open(inputKey)
+		if err != nil {
+			log.Fatalf("failed opening keystroke file: %s", err)
+		}
+		defer file.Close()
+		timeline, err := evaluator.ReadKeyTimeline(file)
+		if err != nil {
+			log.Fatalf("failed reading keystroke file: %s", err)
+		}
+		e.Key = timeline
+	}
+
+	if clockTime != "" || clockStep != 0 {
+		start := time.Now()
+		if clockTime != "" {
+			start, err = time.Parse("15:04:05", clockTime)
+			if err != nil {
+				log.Fatalf("failed parsing time: %s", err)
+			}
+		}
+		e.Clock = evaluator.FixedClock{Start: start, Step: clockStep}
+	}
+
+	if netListen != "" {
+		n, err := evaluator.ListenUDPNet(netListen)
+		if err != nil {
+			log.Fatalf("failed listening net: %s", err)
+		}
+		defer n.Close()
+		for _, peer := range strings.Split(netPeers, ",") {
+			if peer == "" {
+				continue
+			}
+			if err := n.AddPeer(peer); err != nil {
+				log.Fatalf("failed adding net peer: %s", err)
+			}
+		}
+		e.Net = n
+	}
 
 	img := e.Eval(inputFile, input)
 
@@ -69,4 +198,60 @@ func main() {
 			log.Fatalf("failed encoding gif: %s", err)
 		}
 	}
+
+	if outputSVG != "" {
+		file, err := os.Create(outputSVG)
+		if err != nil {
+			log.Fatalf("failed creating output svg file: %s", err)
+		}
+		defer file.Close()
+		if err := evaluator.EncodeSVG(file, e.Drawing, e.Scale); err != nil {
+			log.Fatalf("failed encoding svg: %s", err)
+		}
+	}
+
+	if outputPDF != "" {
+		page, err := evaluator.ParsePageSize(pageSize)
+		if err != nil {
+			log.Fatal(err)
+		}
+		file, err := os.Create(outputPDF)
+		if err != nil {
+			log.Fatalf("failed creating output pdf file: %s", err)
+		}
+		defer file.Close()
+		if err := evaluator.EncodePDF(file, e.Drawing, page); err != nil {
+			log.Fatalf("failed encoding pdf: %s", err)
+		}
+	}
+
+	if outputPlot != "" {
+		options := evaluator.PlotOptions{Pens: pens}
+		options.Format, err = evaluator.ParsePlotFormat(plotFormat)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if _, err := fmt.Sscanf(bed, "%gx%g", &options.BedWidth, &options.BedHeight); err != nil || options.BedWidth <= 0 || options.BedHeight <= 0 {
+			log.Fatalf("bed must be WxH in mm: %s", bed)
+		}
+		file, err := os.Create(outputPlot)
+		if err != nil {
+			log.Fatalf("failed creating output plot file: %s", err)
+		}
+		defer file.Close()
+		if err := evaluator.EncodePlot(file, e.Drawing, options); err != nil {
+			log.Fatalf("failed encoding plot: %s", err)
+		}
+	}
+
+	if outputOps != "" {
+		file, err := os.Create(outputOps)
+		if err != nil {
+			log.Fatalf("failed creating output ops file: %s", err)
+		}
+		defer file.Close()
+		if err := evaluator.EncodeJSON(file, e.Drawing); err != nil {
+			log.Fatalf("failed encoding ops: %s", err)
+		}
+	}
 }
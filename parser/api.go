@@ -1,5 +1,10 @@
 package parser
 
+func init() {
+	// Lexer.Error rewrites the verbose messages into friendlier ones.
+	yyErrorVerbose = true
+}
+
 func Parse(yylex yyLexer) int {
 	yylex.(*Lexer).Whitespace ^= 1 << '\n'
 
@@ -6,9 +6,12 @@ import (
 	"text/scanner"
 )
 
+// Token is a scanned token. Start is where it begins and Position is just
+// past its end.
 type Token struct {
 	Token    int
 	Literal  string
+	Start    scanner.Position
 	Position scanner.Position
 }
 
@@ -17,11 +20,33 @@ func (t *Token) Pos() string {
 	return fmt.Sprintf("%s:%d:%d: ", pos.Filename, pos.Line, pos.Column)
 }
 
-type Expression interface {
+// Span is the source range of a node, from the start of its first token to
+// just past its last one.
+type Span struct {
+	Start scanner.Position
+	End   scanner.Position
+}
+
+func (s Span) StartPos() scanner.Position {
+	return s.Start
+}
+
+func (s Span) EndPos() scanner.Position {
+	return s.End
+}
+
+type Node interface {
 	String() string
+	StartPos() scanner.Position
+	EndPos() scanner.Position
+}
+
+type Expression interface {
+	Node
 }
 
 type IntegerExpression struct {
+	Span
 	Literal string
 }
 
@@ -30,6 +55,7 @@ func (ne *IntegerExpression) String() string {
 }
 
 type IdentifierExpression struct {
+	Span
 	Token Token
 }
 
@@ -38,6 +64,7 @@ func (ie *IdentifierExpression) String() string {
 }
 
 type CalculateExpression struct {
+	Span
 	Left     Expression
 	Operator string
 	Right    Expression
@@ -47,7 +74,27 @@ func (ce *CalculateExpression) String() string {
 	return ce.Left.String() + " " + ce.Operator + " " + ce.Right.String()
 }
 
+// ColorExpression is a gray level or three components, such as red, green
+// and blue, optionally followed by an opacity.
+type ColorExpression struct {
+	Span
+	Values  []Expression
+	Opacity Expression
+}
+
+func (ce *ColorExpression) String() string {
+	values := make([]string, 0, len(ce.Values)+1)
+	for _, v := range ce.Values {
+		values = append(values, v.String())
+	}
+	if ce.Opacity != nil {
+		values = append(values, ce.Opacity.String())
+	}
+	return strings.Join(values, " ")
+}
+
 type CallNumberExpression struct {
+	Span
 	Token     Token
 	Arguments []Expression
 }
@@ -62,11 +109,73 @@ func (ce *CallNumberExpression) String() string {
 	return out
 }
 
+type ArrayExpression struct {
+	Span
+	Token Token
+	Index Expression
+}
+
+func (ae *ArrayExpression) String() string {
+	return "<" + ae.Token.Literal + " " + ae.Index.String() + ">"
+}
+
+type NetExpression struct {
+	Span
+	Token Token
+	Slot  Expression
+}
+
+func (ne *NetExpression) String() string {
+	return "<" + ne.Token.Literal + " " + ne.Slot.String() + ">"
+}
+
 type Statement interface {
-	String() string
+	Node
+}
+
+type SizeStatement struct {
+	Span
+	Token  Token
+	Width  Expression
+	Height Expression
+}
+
+func (ss *SizeStatement) String() string {
+	return "Size " + ss.Width.String() + " " + ss.Height.String()
+}
+
+type BlendStatement struct {
+	Span
+	Token Token
+	Mode  Token
+}
+
+func (bs *BlendStatement) String() string {
+	return "Blend " + bs.Mode.Literal
+}
+
+type AntialiasStatement struct {
+	Span
+	Token Token
+	Value Token
+}
+
+func (as *AntialiasStatement) String() string {
+	return "Antialias " + as.Value.Literal
+}
+
+type WidthStatement struct {
+	Span
+	Token Token
+	Value Expression
+}
+
+func (ws *WidthStatement) String() string {
+	return "Width " + ws.Value.String()
 }
 
 type PaperStatement struct {
+	Span
 	Value Expression
 }
 
@@ -75,6 +184,7 @@ func (ps *PaperStatement) String() string {
 }
 
 type PenStatement struct {
+	Span
 	Value Expression
 }
 
@@ -83,6 +193,7 @@ func (ps *PenStatement) String() string {
 }
 
 type LineStatement struct {
+	Span
 	X1 Expression
 	Y1 Expression
 	X2 Expression
@@ -94,6 +205,7 @@ func (ls *LineStatement) String() string {
 }
 
 type SetStatement struct {
+	Span
 	Name  string
 	Value Expression
 }
@@ -102,7 +214,30 @@ func (ss *SetStatement) String() string {
 	return "Set " + ss.Name + " " + ss.Value.String()
 }
 
+type ArrayStatement struct {
+	Span
+	Token Token
+	Index Expression
+	Value Expression
+}
+
+func (as *ArrayStatement) String() string {
+	return "Set <" + as.Token.Literal + " " + as.Index.String() + "> " + as.Value.String()
+}
+
+type NetStatement struct {
+	Span
+	Token Token
+	Slot  Expression
+	Value Expression
+}
+
+func (ns *NetStatement) String() string {
+	return "Set <" + ns.Token.Literal + " " + ns.Slot.String() + "> " + ns.Value.String()
+}
+
 type DotStatement struct {
+	Span
 	X     Expression
 	Y     Expression
 	Value Expression
@@ -113,16 +248,22 @@ func (ds *DotStatement) String() string {
 }
 
 type CopyStatement struct {
-	Name string
-	X    Expression
-	Y    Expression
+	Span
+	Name    string
+	X       Expression
+	Y       Expression
+	Channel Expression
 }
 
 func (cs *CopyStatement) String() string {
+	if cs.Channel != nil {
+		return "Set " + cs.Name + " [" + cs.X.String() + " " + cs.Y.String() + " " + cs.Channel.String() + "]"
+	}
 	return "Set " + cs.Name + " [" + cs.X.String() + " " + cs.Y.String() + "]"
 }
 
 type BlockStatement struct {
+	Span
 	Statements []Statement
 }
 
@@ -137,6 +278,7 @@ func (bs *BlockStatement) String() string {
 }
 
 type RepeatStatement struct {
+	Span
 	Name string
 	From Expression
 	To   Expression
@@ -147,7 +289,17 @@ func (rs *RepeatStatement) String() string {
 	return "Repeat " + rs.Body.String()
 }
 
+type ForeverStatement struct {
+	Span
+	Body Statement
+}
+
+func (fs *ForeverStatement) String() string {
+	return "Forever " + fs.Body.String()
+}
+
 type SameStatement struct {
+	Span
 	Left  Expression
 	Right Expression
 	Body  Statement
@@ -158,6 +310,7 @@ func (ss *SameStatement) String() string {
 }
 
 type NotSameStatement struct {
+	Span
 	Left  Expression
 	Right Expression
 	Body  Statement
@@ -168,6 +321,7 @@ func (ns *NotSameStatement) String() string {
 }
 
 type SmallerStatement struct {
+	Span
 	Left  Expression
 	Right Expression
 	Body  Statement
@@ -178,6 +332,7 @@ func (ss *SmallerStatement) String() string {
 }
 
 type NotSmallerStatement struct {
+	Span
 	Left  Expression
 	Right Expression
 	Body  Statement
@@ -188,6 +343,7 @@ func (ns *NotSmallerStatement) String() string {
 }
 
 type DefineCommandStatement struct {
+	Span
 	Name       string
 	Body       Statement
 	Parameters []string
@@ -198,6 +354,7 @@ func (fs *DefineCommandStatement) String() string {
 }
 
 type CallCommandStatement struct {
+	Span
 	Token     Token
 	Arguments []Expression
 }
@@ -212,6 +369,7 @@ func (cs *CallCommandStatement) String() string {
 }
 
 type LoadStatement struct {
+	Span
 	Token Token
 }
 
@@ -220,6 +378,7 @@ func (ls *LoadStatement) String() string {
 }
 
 type DefineNumberStatement struct {
+	Span
 	Name       string
 	Body       Statement
 	Parameters []string
@@ -230,6 +389,7 @@ func (fs *DefineNumberStatement) String() string {
 }
 
 type ValueStatement struct {
+	Span
 	Result Expression
 }
 
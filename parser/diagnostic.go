@@ -0,0 +1,65 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"text/scanner"
+)
+
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	}
+	return fmt.Sprintf("severity(%d)", int(s))
+}
+
+const CodeSyntaxError = "syntax-error"
+
+// Diagnostic is an error or warning found while parsing or evaluating.
+// Pos is the first column of the source range it covers and End is just past
+// the last one. Reported is where the text format points when that is not
+// Pos, as for evaluator errors about a token, which have always pointed just
+// past it.
+type Diagnostic struct {
+	Pos      scanner.Position
+	End      scanner.Position
+	Reported scanner.Position
+	Severity Severity
+	Code     string
+	Message  string
+	Related  []Related
+}
+
+// Related points at another place involved in a diagnostic, such as the
+// definition of a Command that was called wrongly.
+type Related struct {
+	Pos     scanner.Position
+	End     scanner.Position
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	pos := d.Pos
+	if d.Reported.IsValid() {
+		pos = d.Reported
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", pos.Filename, pos.Line, pos.Column, d.Message)
+}
+
+func FormatDiagnostics(diagnostics []Diagnostic) string {
+	lines := make([]string, len(diagnostics))
+	for i, d := range diagnostics {
+		lines[i] = d.String()
+	}
+	return strings.Join(lines, "\n")
+}
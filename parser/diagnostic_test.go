@@ -0,0 +1,80 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+	"text/scanner"
+)
+
+func TestDiagnostic(t *testing.T) {
+	diagnostics := []Diagnostic{
+		{
+			Pos:     scanner.Position{Filename: "test", Line: 1, Column: 2},
+			Message: "first",
+		},
+		{
+			Pos:     scanner.Position{Filename: "test", Line: 3, Column: 4},
+			Message: "second",
+		},
+	}
+
+	expect := "test:1:2: first"
+	actual := diagnostics[0].String()
+	if expect != actual {
+		t.Errorf("expect %s, but got %s", expect, actual)
+	}
+
+	expect = "test:1:2: first\ntest:3:4: second"
+	actual = FormatDiagnostics(diagnostics)
+	if expect != actual {
+		t.Errorf("expect %s, but got %s", expect, actual)
+	}
+
+	reported := Diagnostic{
+		Pos:      scanner.Position{Filename: "test", Line: 1, Column: 7},
+		End:      scanner.Position{Filename: "test", Line: 1, Column: 8},
+		Reported: scanner.Position{Filename: "test", Line: 1, Column: 8},
+		Message:  "third",
+	}
+	expect = "test:1:8: third"
+	actual = reported.String()
+	if expect != actual {
+		t.Errorf("expect %s, but got %s", expect, actual)
+	}
+}
+
+func TestSeverity(t *testing.T) {
+	tests := []struct {
+		severity Severity
+		expected string
+	}{
+		{SeverityError, "error"},
+		{SeverityWarning, "warning"},
+		{Severity(9), "severity(9)"},
+	}
+
+	for i, test := range tests {
+		if test.severity.String() != test.expected {
+			t.Errorf("test %d: expected %s, got %s", i, test.expected, test.severity)
+		}
+	}
+}
+
+func TestSyntaxDiagnostic(t *testing.T) {
+	l := new(Lexer)
+	l.Filename = "test.dbn"
+	l.Init(strings.NewReader("Paper 100 Paper 100\n"))
+
+	Parse(l)
+
+	if len(l.Errors) != 1 {
+		t.Fatalf("expected 1 error, got %d", len(l.Errors))
+	}
+	d := l.Errors[0]
+	if d.Code != CodeSyntaxError || d.Severity != SeverityError {
+		t.Errorf("expected a syntax error, got %s %s", d.Severity, d.Code)
+	}
+	if d.Pos.Line != 1 || d.Pos.Column != 11 || d.End.Column != 16 {
+		t.Errorf("expected 1:11 to 1:16, got %d:%d to %d:%d", d.Pos.Line, d.Pos.Column, d.End.Line, d.End.Column)
+	}
+}
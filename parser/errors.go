@@ -0,0 +1,107 @@
+package parser
+
+import "strings"
+
+var statementNames = map[int]string{
+	PAPER:      "Paper",
+	PEN:        "Pen",
+	LINE:       "Line",
+	SET:        "Set",
+	REPEAT:     "Repeat",
+	SAME:       "Same?",
+	NOTSAME:    "NotSame?",
+	SMALLER:    "Smaller?",
+	NOTSMALLER: "NotSmaller?",
+	COMMAND:    "Command",
+	LOAD:       "Load",
+	NUMBER:     "Number",
+	VALUE:      "Value",
+	FOREVER:    "Forever",
+	SIZE:       "Size",
+	BLEND:      "Blend",
+	ANTIALIAS:  "Antialias",
+	WIDTH:      "Width",
+}
+
+// expectedNames are the names goyacc gives tokens in verbose syntax errors
+// and how they are shown instead.
+var expectedNames = map[string]string{
+	"$end":       "end of file",
+	"LF":         "newline",
+	"LBRACE":     "`{`",
+	"RBRACE":     "`}`",
+	"LBRACKET":   "`[`",
+	"RBRACKET":   "`]`",
+	"LPAREN":     "`(`",
+	"RPAREN":     "`)`",
+	"LT":         "`<`",
+	"GT":         "`>`",
+	"INTEGER":    "number",
+	"IDENTIFIER": "name",
+	"STRING":     "string",
+	"ARRAY":      "`Array`",
+	"NET":        "`Net`",
+	"PAPER":      "`Paper`",
+	"PEN":        "`Pen`",
+	"LINE":       "`Line`",
+	"SET":        "`Set`",
+	"REPEAT":     "`Repeat`",
+	"SAME":       "`Same?`",
+	"NOTSAME":    "`NotSame?`",
+	"SMALLER":    "`Smaller?`",
+	"NOTSMALLER": "`NotSmaller?`",
+	"COMMAND":    "`Command`",
+	"LOAD":       "`Load`",
+	"NUMBER":     "`Number`",
+	"VALUE":      "`Value`",
+	"FOREVER":    "`Forever`",
+	"SIZE":       "`Size`",
+	"BLEND":      "`Blend`",
+	"ANTIALIAS":  "`Antialias`",
+	"WIDTH":      "`Width`",
+	"'+'":        "`+`",
+	"'-'":        "`-`",
+	"'*'":        "`*`",
+	"'/'":        "`/`",
+}
+
+// expressionStarts is what goyacc expects where an expression must go.
+const expressionStarts = "INTEGER or IDENTIFIER or LPAREN or LT"
+
+// syntaxErrorMessage rewrites a verbose goyacc error, such as "syntax error:
+// unexpected RBRACKET, expecting LF or RBRACE", with the text of the token
+// found, the statement it is in and readable names for what was expected.
+// goyacc leaves out what was expected when more than four tokens would do.
+func syntaxErrorMessage(e string, found Token, statement Token) string {
+	out := "syntax error: unexpected " + foundName(found)
+	if name, ok := statementNames[statement.Token]; ok && statement != found {
+		out += " in " + name + " statement"
+	}
+
+	_, expecting, ok := strings.Cut(e, ", expecting ")
+	if !ok {
+		return out
+	}
+	if expecting == expressionStarts {
+		return out + ", expected expression"
+	}
+	names := strings.Split(expecting, " or ")
+	for i, name := range names {
+		if readable, ok := expectedNames[name]; ok {
+			names[i] = readable
+		}
+	}
+	return out + ", expected " + strings.Join(names, " or ")
+}
+
+func foundName(found Token) string {
+	switch found.Token {
+	case 0:
+		return "end of file"
+	case LF:
+		return "newline"
+	case STRING:
+		return "`\"" + found.Literal + "\"`"
+	}
+	return "`" + found.Literal + "`"
+}
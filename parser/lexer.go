@@ -1,7 +1,6 @@
 package parser
 
 import (
-	"fmt"
 	"strings"
 	"text/scanner"
 )
@@ -9,7 +8,9 @@ import (
 type Lexer struct {
 	scanner.Scanner
 	Statements []Statement
-	Errors     []string
+	Errors     []Diagnostic
+	last       Token
+	statement  Token
 }
 
 func (l *Lexer) Lex(lval *yySymType) int {
@@ -61,6 +62,20 @@ func (l *Lexer) Lex(lval *yySymType) int {
 			token = NUMBER
 		case "Value", "value":
 			token = VALUE
+		case "Array", "array":
+			token = l.numberKeyword(ARRAY)
+		case "Forever", "forever":
+			token = l.statementKeyword(FOREVER)
+		case "Size", "size":
+			token = l.statementKeyword(SIZE)
+		case "Blend", "blend":
+			token = l.statementKeyword(BLEND)
+		case "Antialias", "antialias":
+			token = l.statementKeyword(ANTIALIAS)
+		case "Width", "width":
+			token = l.statementKeyword(WIDTH)
+		case "Net", "net":
+			token = l.numberKeyword(NET)
 		default:
 			token = IDENTIFIER
 		}
@@ -85,11 +100,50 @@ func (l *Lexer) Lex(lval *yySymType) int {
 	case scanner.EOF:
 		token = 0
 	}
-	lval.token = Token{Token: token, Literal: literal, Position: l.Pos()}
+	lval.token = Token{Token: token, Literal: literal, Start: l.Position, Position: l.Pos()}
+
+	if l.atStatementStart() {
+		l.statement = lval.token
+	}
+	l.last = lval.token
 
 	return token
 }
 
+// atStatementStart reports whether the token being read starts a statement.
+func (l *Lexer) atStatementStart() bool {
+	switch l.last.Token {
+	case 0, LF, LBRACE, RBRACE:
+		return true
+	}
+	return false
+}
+
+// statementKeyword returns keyword for a word that only names a statement
+// at the start of one, so that elsewhere it stays free as a name, such as
+// `Set size 10`.
+func (l *Lexer) statementKeyword(keyword int) int {
+	if l.atStatementStart() {
+		return keyword
+	}
+	return IDENTIFIER
+}
+
+// numberKeyword returns keyword for a word that only names a built-in right
+// after `<`, as in `<Array 1>`, so that elsewhere it stays free as a name.
+func (l *Lexer) numberKeyword(keyword int) int {
+	if l.last.Token == LT {
+		return keyword
+	}
+	return IDENTIFIER
+}
+
 func (l *Lexer) Error(e string) {
-	l.Errors = append(l.Errors, fmt.Sprintf("%s:%d:%d: %s", l.Filename, l.Line, l.Column, e))
+	l.Errors = append(l.Errors, Diagnostic{
+		Pos:      l.Position,
+		End:      l.Pos(),
+		Severity: SeverityError,
+		Code:     CodeSyntaxError,
+		Message:  syntaxErrorMessage(e, l.last, l.statement),
+	})
 }
@@ -1,5 +1,14 @@
 %{
 package parser
+
+import "text/scanner"
+
+func argumentsEnd(token Token, arguments []Expression) scanner.Position {
+    if len(arguments) == 0 {
+        return token.Position
+    }
+    return arguments[len(arguments)-1].EndPos()
+}
 %}
 
 %union{
@@ -9,21 +18,23 @@ package parser
     token Token
     parameters []string
     arguments []Expression
+    block *BlockStatement
 }
 
-%type<statements> statements body
+%type<statements> statements
+%type<block> body
 
 %type<statement> statement command
-%type<statement> paper pen line set dot copy repeat same notsame smaller notsmaller definecommand callcommand load definenumber value
+%type<statement> size blend antialias width paper pen line set array net dot copy repeat forever same notsame smaller notsmaller definecommand callcommand load definenumber value
 %type<statement> block
 
-%type<expression> expression
+%type<expression> expression color
 
 %type<parameters> parameters
 %type<arguments> arguments
 
 %token<token> INTEGER LF IDENTIFIER OPERATOR
-%token<token> PAPER PEN LINE SET REPEAT SAME NOTSAME SMALLER NOTSMALLER COMMAND LOAD NUMBER VALUE
+%token<token> PAPER PEN LINE SET REPEAT SAME NOTSAME SMALLER NOTSMALLER COMMAND LOAD NUMBER VALUE ARRAY FOREVER NET SIZE BLEND ANTIALIAS WIDTH
 %token<token> LBRACE RBRACE LPAREN RPAREN LBRACKET RBRACKET LT GT
 %token<token> STRING
 
@@ -50,38 +61,58 @@ statements
     }
 
 block
-    : LBRACE body RBRACE
+    : LBRACE body
     {
-        $$ = &BlockStatement{Statements: $2}
+        $2.Start = $1.Start
+        $$ = $2
     }
 
 body
-    : /* empty block */
+    : RBRACE /* empty block */
     {
-        $$ = []Statement{}
+        $$ = &BlockStatement{Span: Span{End: $1.Position}, Statements: []Statement{}}
     }
-    | command /* no newline at end of block */
+    | command RBRACE /* no newline at end of block */
     {
-        $$ = []Statement{$1}
+        $$ = &BlockStatement{Span: Span{End: $2.Position}, Statements: []Statement{$1}}
     }
     | statement body
     {
-        $$ = append([]Statement{$1}, $2...)
+        $2.Statements = append([]Statement{$1}, $2.Statements...)
+        $$ = $2
+    }
+    | error RBRACE /* broken line at end of block */
+    {
+        $$ = &BlockStatement{Span: Span{End: $2.Position}, Statements: []Statement{}}
+        Errflag = 0
     }
 
 statement
     : command LF
     | LF { $$ = nil }
+    | error LF
+    {
+        /* skip to the end of the broken line and report the next error too */
+        $$ = nil
+        Errflag = 0
+    }
 
 command
-    : paper
+    : size
+    | blend
+    | antialias
+    | width
+    | paper
     | pen
     | line
     | set
+    | array
+    | net
     | dot
     | copy
     | block
     | repeat
+    | forever
     | same
     | notsame
     | smaller
@@ -92,46 +123,92 @@ command
     | definenumber
     | value
 
+size
+    : SIZE expression expression
+    {
+        $$ = &SizeStatement{Span: Span{Start: $1.Start, End: $3.EndPos()}, Token: $1, Width: $2, Height: $3}
+    }
+
+blend
+    : BLEND IDENTIFIER
+    {
+        $$ = &BlendStatement{Span: Span{Start: $1.Start, End: $2.Position}, Token: $1, Mode: $2}
+    }
+
+antialias
+    : ANTIALIAS IDENTIFIER
+    {
+        $$ = &AntialiasStatement{Span: Span{Start: $1.Start, End: $2.Position}, Token: $1, Value: $2}
+    }
+
+width
+    : WIDTH expression
+    {
+        $$ = &WidthStatement{Span: Span{Start: $1.Start, End: $2.EndPos()}, Token: $1, Value: $2}
+    }
+
 paper
-    : PAPER expression
+    : PAPER color
     {
-        $$ = &PaperStatement{Value: $2}
+        $$ = &PaperStatement{Span: Span{Start: $1.Start, End: $2.EndPos()}, Value: $2}
     }
 
 pen
-    : PEN expression
+    : PEN color
     {
-        $$ = &PenStatement{Value: $2}
+        $$ = &PenStatement{Span: Span{Start: $1.Start, End: $2.EndPos()}, Value: $2}
     }
 
 line
     : LINE expression expression expression expression
     {
-        $$ = &LineStatement{X1: $2, Y1: $3, X2: $4, Y2: $5}
+        $$ = &LineStatement{Span: Span{Start: $1.Start, End: $5.EndPos()}, X1: $2, Y1: $3, X2: $4, Y2: $5}
     }
 
 set
     : SET IDENTIFIER expression
     {
-        $$ = &SetStatement{Name: $2.Literal, Value: $3}
+        $$ = &SetStatement{Span: Span{Start: $1.Start, End: $3.EndPos()}, Name: $2.Literal, Value: $3}
+    }
+
+array
+    : SET LT ARRAY expression GT expression
+    {
+        $$ = &ArrayStatement{Span: Span{Start: $1.Start, End: $6.EndPos()}, Token: $3, Index: $4, Value: $6}
+    }
+
+net
+    : SET LT NET expression GT expression
+    {
+        $$ = &NetStatement{Span: Span{Start: $1.Start, End: $6.EndPos()}, Token: $3, Slot: $4, Value: $6}
     }
 
 dot
-    : SET LBRACKET expression expression RBRACKET expression
+    : SET LBRACKET expression expression RBRACKET color
     {
-        $$ = &DotStatement{X: $3, Y: $4, Value: $6}
+        $$ = &DotStatement{Span: Span{Start: $1.Start, End: $6.EndPos()}, X: $3, Y: $4, Value: $6}
     }
 
 copy
     : SET IDENTIFIER LBRACKET expression expression RBRACKET
     {
-        $$ = &CopyStatement{Name: $2.Literal, X: $4, Y: $5}
+        $$ = &CopyStatement{Span: Span{Start: $1.Start, End: $6.Position}, Name: $2.Literal, X: $4, Y: $5}
+    }
+    | SET IDENTIFIER LBRACKET expression expression expression RBRACKET
+    {
+        $$ = &CopyStatement{Span: Span{Start: $1.Start, End: $7.Position}, Name: $2.Literal, X: $4, Y: $5, Channel: $6}
     }
 
 repeat
     : REPEAT IDENTIFIER expression expression newline block
     {
-        $$ = &RepeatStatement{Name: $2.Literal, From: $3, To: $4, Body: $6}
+        $$ = &RepeatStatement{Span: Span{Start: $1.Start, End: $6.EndPos()}, Name: $2.Literal, From: $3, To: $4, Body: $6}
+    }
+
+forever
+    : FOREVER newline block
+    {
+        $$ = &ForeverStatement{Span: Span{Start: $1.Start, End: $3.EndPos()}, Body: $3}
     }
 
 newline
@@ -141,31 +218,31 @@ newline
 same
     : SAME expression expression newline block
     {
-        $$ = &SameStatement{Left: $2, Right: $3, Body: $5}
+        $$ = &SameStatement{Span: Span{Start: $1.Start, End: $5.EndPos()}, Left: $2, Right: $3, Body: $5}
     }
 
 notsame
     : NOTSAME expression expression newline block
     {
-        $$ = &NotSameStatement{Left: $2, Right: $3, Body: $5}
+        $$ = &NotSameStatement{Span: Span{Start: $1.Start, End: $5.EndPos()}, Left: $2, Right: $3, Body: $5}
     }
 
 smaller
     : SMALLER expression expression newline block
     {
-        $$ = &SmallerStatement{Left: $2, Right: $3, Body: $5}
+        $$ = &SmallerStatement{Span: Span{Start: $1.Start, End: $5.EndPos()}, Left: $2, Right: $3, Body: $5}
     }
 
 notsmaller
     : NOTSMALLER expression expression newline block
     {
-        $$ = &NotSmallerStatement{Left: $2, Right: $3, Body: $5}
+        $$ = &NotSmallerStatement{Span: Span{Start: $1.Start, End: $5.EndPos()}, Left: $2, Right: $3, Body: $5}
     }
 
 definecommand
     : COMMAND IDENTIFIER parameters newline block
     {
-        $$ = &DefineCommandStatement{Name: $2.Literal, Parameters: $3, Body: $5}
+        $$ = &DefineCommandStatement{Span: Span{Start: $1.Start, End: $5.EndPos()}, Name: $2.Literal, Parameters: $3, Body: $5}
     }
 
 parameters
@@ -181,13 +258,13 @@ parameters
 callcommand
     : IDENTIFIER arguments
     {
-        $$ = &CallCommandStatement{Token: $1, Arguments: $2}
+        $$ = &CallCommandStatement{Span: Span{Start: $1.Start, End: argumentsEnd($1, $2)}, Token: $1, Arguments: $2}
     }
 
 definenumber
     : NUMBER IDENTIFIER parameters newline block
     {
-        $$ = &DefineNumberStatement{Name: $2.Literal, Parameters: $3, Body: $5}
+        $$ = &DefineNumberStatement{Span: Span{Start: $1.Start, End: $5.EndPos()}, Name: $2.Literal, Parameters: $3, Body: $5}
     }
 
 arguments
@@ -203,43 +280,66 @@ arguments
 load
     : LOAD STRING
     {
-        $$ = &LoadStatement{Token: $2}
+        $$ = &LoadStatement{Span: Span{Start: $1.Start, End: $2.Position}, Token: $2}
     }
 
 value
    : VALUE expression
    {
-       $$ = &ValueStatement{Result: $2}
+       $$ = &ValueStatement{Span: Span{Start: $1.Start, End: $2.EndPos()}, Result: $2}
    } 
 
+color
+    : expression
+    | expression expression /* gray and opacity */
+    {
+        $$ = &ColorExpression{Span: Span{Start: $1.StartPos(), End: $2.EndPos()}, Values: []Expression{$1}, Opacity: $2}
+    }
+    | expression expression expression
+    {
+        $$ = &ColorExpression{Span: Span{Start: $1.StartPos(), End: $3.EndPos()}, Values: []Expression{$1, $2, $3}}
+    }
+    | expression expression expression expression
+    {
+        $$ = &ColorExpression{Span: Span{Start: $1.StartPos(), End: $4.EndPos()}, Values: []Expression{$1, $2, $3}, Opacity: $4}
+    }
+
 expression
     : INTEGER
     {
-        $$ = &IntegerExpression{Literal: $1.Literal}
+        $$ = &IntegerExpression{Span: Span{Start: $1.Start, End: $1.Position}, Literal: $1.Literal}
     }
     | IDENTIFIER
     {
-        $$ = &IdentifierExpression{Token: $1}
+        $$ = &IdentifierExpression{Span: Span{Start: $1.Start, End: $1.Position}, Token: $1}
     }
     | expression '+' expression
     {
-        $$ = &CalculateExpression{Left: $1, Operator: "+", Right: $3}
+        $$ = &CalculateExpression{Span: Span{Start: $1.StartPos(), End: $3.EndPos()}, Left: $1, Operator: "+", Right: $3}
     }
     | expression '-' expression
     {
-        $$ = &CalculateExpression{Left: $1, Operator: "-", Right: $3}
+        $$ = &CalculateExpression{Span: Span{Start: $1.StartPos(), End: $3.EndPos()}, Left: $1, Operator: "-", Right: $3}
     }
     | expression '*' expression
     {
-        $$ = &CalculateExpression{Left: $1, Operator: "*", Right: $3}
+        $$ = &CalculateExpression{Span: Span{Start: $1.StartPos(), End: $3.EndPos()}, Left: $1, Operator: "*", Right: $3}
     }
     | expression '/' expression
     {
-        $$ = &CalculateExpression{Left: $1, Operator: "/", Right: $3}
+        $$ = &CalculateExpression{Span: Span{Start: $1.StartPos(), End: $3.EndPos()}, Left: $1, Operator: "/", Right: $3}
     }
     | LT IDENTIFIER arguments GT
     {
-        $$ = &CallNumberExpression{Token: $2, Arguments: $3}
+        $$ = &CallNumberExpression{Span: Span{Start: $1.Start, End: $4.Position}, Token: $2, Arguments: $3}
+    }
+    | LT ARRAY expression GT
+    {
+        $$ = &ArrayExpression{Span: Span{Start: $1.Start, End: $4.Position}, Token: $2, Index: $3}
+    }
+    | LT NET expression GT
+    {
+        $$ = &NetExpression{Span: Span{Start: $1.Start, End: $4.Position}, Token: $2, Slot: $3}
     }
     | LPAREN expression RPAREN
     {
@@ -16,6 +16,16 @@ func TestParser(t *testing.T) {
 				&PaperStatement{Value: &IntegerExpression{Literal: "100"}},
 			},
 		},
+		{
+			input: "Size 200 100\n",
+			expected: []Statement{
+				&SizeStatement{
+					Token:  Token{Literal: "Size"},
+					Width:  &IntegerExpression{Literal: "200"},
+					Height: &IntegerExpression{Literal: "100"},
+				},
+			},
+		},
 		{
 			input: "Pen (10 + 10)",
 			expected: []Statement{
@@ -46,6 +56,117 @@ func TestParser(t *testing.T) {
 				},
 			},
 		},
+		{
+			input: "Set blend 1",
+			expected: []Statement{
+				&SetStatement{
+					Name:  "blend",
+					Value: &IntegerExpression{Literal: "1"},
+				},
+			},
+		},
+		{
+			input: "Set antialias 1",
+			expected: []Statement{
+				&SetStatement{
+					Name:  "antialias",
+					Value: &IntegerExpression{Literal: "1"},
+				},
+			},
+		},
+		{
+			input: "Set forever 1",
+			expected: []Statement{
+				&SetStatement{
+					Name:  "forever",
+					Value: &IntegerExpression{Literal: "1"},
+				},
+			},
+		},
+		{
+			input: "Set net 1\nPaper net",
+			expected: []Statement{
+				&SetStatement{
+					Name:  "net",
+					Value: &IntegerExpression{Literal: "1"},
+				},
+				&PaperStatement{Value: &IdentifierExpression{Token: Token{Literal: "net"}}},
+			},
+		},
+		{
+			input: "Set array 1\nPaper array",
+			expected: []Statement{
+				&SetStatement{
+					Name:  "array",
+					Value: &IntegerExpression{Literal: "1"},
+				},
+				&PaperStatement{Value: &IdentifierExpression{Token: Token{Literal: "array"}}},
+			},
+		},
+		{
+			input: "Set size 10\nLine 0 0 size size\nCommand Grow size { Size size size }",
+			expected: []Statement{
+				&SetStatement{
+					Name:  "size",
+					Value: &IntegerExpression{Literal: "10"},
+				},
+				&LineStatement{
+					X1: &IntegerExpression{Literal: "0"},
+					Y1: &IntegerExpression{Literal: "0"},
+					X2: &IdentifierExpression{Token: Token{Literal: "size"}},
+					Y2: &IdentifierExpression{Token: Token{Literal: "size"}},
+				},
+				&DefineCommandStatement{
+					Name:       "Grow",
+					Parameters: []string{"size"},
+					Body: &BlockStatement{
+						Statements: []Statement{
+							&SizeStatement{
+								Token:  Token{Literal: "Size"},
+								Width:  &IdentifierExpression{Token: Token{Literal: "size"}},
+								Height: &IdentifierExpression{Token: Token{Literal: "size"}},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			input: "Set <Array 1> 100\nPaper <Array (1 + 1)>",
+			expected: []Statement{
+				&ArrayStatement{
+					Token: Token{Literal: "Array"},
+					Index: &IntegerExpression{Literal: "1"},
+					Value: &IntegerExpression{Literal: "100"},
+				},
+				&PaperStatement{
+					Value: &ArrayExpression{
+						Token: Token{Literal: "Array"},
+						Index: &CalculateExpression{
+							Left:     &IntegerExpression{Literal: "1"},
+							Operator: "+",
+							Right:    &IntegerExpression{Literal: "1"},
+						},
+					},
+				},
+			},
+		},
+		{
+			input: "Set <Net 1> 100\nPaper <Net 1>",
+			expected: []Statement{
+				&NetStatement{
+					Token: Token{Literal: "Net"},
+					Slot:  &IntegerExpression{Literal: "1"},
+					Value: &IntegerExpression{Literal: "100"},
+				},
+				&PaperStatement{
+					Value: &NetExpression{
+						Token: Token{Literal: "Net"},
+						Slot:  &IntegerExpression{Literal: "1"},
+					},
+				},
+			},
+		},
 		{
 			input: "Set [1 2] 100",
 			expected: []Statement{
@@ -66,6 +187,78 @@ func TestParser(t *testing.T) {
 				},
 			},
 		},
+		{
+			input: "Paper 100 50 (10 + 10)\nPen A B C\nSet [1 2] 100 0 0\nSet X [1 2 3]",
+			expected: []Statement{
+				&PaperStatement{Value: &ColorExpression{Values: []Expression{
+					&IntegerExpression{Literal: "100"},
+					&IntegerExpression{Literal: "50"},
+					&CalculateExpression{
+						Left:     &IntegerExpression{Literal: "10"},
+						Operator: "+",
+						Right:    &IntegerExpression{Literal: "10"},
+					},
+				}}},
+				&PenStatement{Value: &ColorExpression{Values: []Expression{
+					&IdentifierExpression{Token: Token{Literal: "A"}},
+					&IdentifierExpression{Token: Token{Literal: "B"}},
+					&IdentifierExpression{Token: Token{Literal: "C"}},
+				}}},
+				&DotStatement{
+					X: &IntegerExpression{Literal: "1"},
+					Y: &IntegerExpression{Literal: "2"},
+					Value: &ColorExpression{Values: []Expression{
+						&IntegerExpression{Literal: "100"},
+						&IntegerExpression{Literal: "0"},
+						&IntegerExpression{Literal: "0"},
+					}},
+				},
+				&CopyStatement{
+					Name:    "X",
+					X:       &IntegerExpression{Literal: "1"},
+					Y:       &IntegerExpression{Literal: "2"},
+					Channel: &IntegerExpression{Literal: "3"},
+				},
+			},
+		},
+		{
+			input: "Blend multiply\nPen 100 50\nPaper 100 0 0 25",
+			expected: []Statement{
+				&BlendStatement{Token: Token{Literal: "Blend"}, Mode: Token{Literal: "multiply"}},
+				&PenStatement{Value: &ColorExpression{
+					Values:  []Expression{&IntegerExpression{Literal: "100"}},
+					Opacity: &IntegerExpression{Literal: "50"},
+				}},
+				&PaperStatement{Value: &ColorExpression{
+					Values: []Expression{
+						&IntegerExpression{Literal: "100"},
+						&IntegerExpression{Literal: "0"},
+						&IntegerExpression{Literal: "0"},
+					},
+					Opacity: &IntegerExpression{Literal: "25"},
+				}},
+			},
+		},
+		{
+			input: "Antialias on\nAntialias off",
+			expected: []Statement{
+				&AntialiasStatement{Token: Token{Literal: "Antialias"}, Value: Token{Literal: "on"}},
+				&AntialiasStatement{Token: Token{Literal: "Antialias"}, Value: Token{Literal: "off"}},
+			},
+		},
+		{
+			input: "Width (A + 1)",
+			expected: []Statement{
+				&WidthStatement{
+					Token: Token{Literal: "Width"},
+					Value: &CalculateExpression{
+						Left:     &IdentifierExpression{Token: Token{Literal: "A"}},
+						Operator: "+",
+						Right:    &IntegerExpression{Literal: "1"},
+					},
+				},
+			},
+		},
 		{
 			input: "Repeat X 0 10 { Pen X }",
 			expected: []Statement{
@@ -81,6 +274,43 @@ func TestParser(t *testing.T) {
 				},
 			},
 		},
+		{
+			input: "Set width 3\nWidth width\nCommand Thick width { Width width }",
+			expected: []Statement{
+				&SetStatement{
+					Name:  "width",
+					Value: &IntegerExpression{Literal: "3"},
+				},
+				&WidthStatement{
+					Token: Token{Literal: "Width"},
+					Value: &IdentifierExpression{Token: Token{Literal: "width"}},
+				},
+				&DefineCommandStatement{
+					Name:       "Thick",
+					Parameters: []string{"width"},
+					Body: &BlockStatement{
+						Statements: []Statement{
+							&WidthStatement{
+								Token: Token{Literal: "Width"},
+								Value: &IdentifierExpression{Token: Token{Literal: "width"}},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			input: "Forever\n{ Pen X }",
+			expected: []Statement{
+				&ForeverStatement{
+					Body: &BlockStatement{
+						Statements: []Statement{
+							&PenStatement{Value: &IdentifierExpression{Token: Token{Literal: "X"}}},
+						},
+					},
+				},
+			},
+		},
 		{
 			input: "Same? 0 10 { Pen X }",
 			expected: []Statement{
@@ -166,7 +396,7 @@ func TestParser(t *testing.T) {
 			input: "Load \"a.dbn\"",
 			expected: []Statement{
 				&LoadStatement{
-					Token{Literal: "a.dbn"},
+					Token: Token{Literal: "a.dbn"},
 				},
 			},
 		},
@@ -218,6 +448,51 @@ func TestParser(t *testing.T) {
 	}
 }
 
+func TestPositions(t *testing.T) {
+	l := new(Lexer)
+	l.Init(strings.NewReader("Paper 100\nLine 0 0 (1 + 2) <Test 3>\nRepeat A 0 10\n{\n  Pen A\n}\nBox\nSet X [1 2]"))
+
+	Parse(l)
+
+	if len(l.Errors) > 0 {
+		t.Fatalf("expected no errors, got %v", l.Errors)
+	}
+
+	spans := func(node Node) [4]int {
+		return [4]int{node.StartPos().Line, node.StartPos().Column, node.EndPos().Line, node.EndPos().Column}
+	}
+
+	statements := []Statement{}
+	for _, statement := range l.Statements {
+		if statement != nil {
+			statements = append(statements, statement)
+		}
+	}
+
+	tests := []struct {
+		node     Node
+		expected [4]int
+	}{
+		{statements[0], [4]int{1, 1, 1, 10}},
+		{statements[0].(*PaperStatement).Value, [4]int{1, 7, 1, 10}},
+		{statements[1], [4]int{2, 1, 2, 26}},
+		{statements[1].(*LineStatement).X2, [4]int{2, 11, 2, 16}},
+		{statements[1].(*LineStatement).Y2, [4]int{2, 18, 2, 26}},
+		{statements[2], [4]int{3, 1, 6, 2}},
+		{statements[2].(*RepeatStatement).Body, [4]int{4, 1, 6, 2}},
+		{statements[2].(*RepeatStatement).Body.(*BlockStatement).Statements[1], [4]int{5, 3, 5, 8}},
+		{statements[3], [4]int{7, 1, 7, 4}},
+		{statements[4], [4]int{8, 1, 8, 12}},
+	}
+
+	for i, test := range tests {
+		actual := spans(test.node)
+		if actual != test.expected {
+			t.Errorf("test %d: expected %v, got %v", i, test.expected, actual)
+		}
+	}
+}
+
 func TestErrors(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -226,7 +501,78 @@ func TestErrors(t *testing.T) {
 		{
 			input: "Paper 100 Paper 100\n",
 			expected: []string{
-				"test.dbn:1:11: syntax error",
+				"test.dbn:1:11: syntax error: unexpected `Paper` in Paper statement",
+			},
+		},
+		{
+			input: "Command {",
+			expected: []string{
+				"test.dbn:1:9: syntax error: unexpected `{` in Command statement, expected name",
+			},
+		},
+		{
+			input: "Line 1 2 ]",
+			expected: []string{
+				"test.dbn:1:10: syntax error: unexpected `]` in Line statement",
+			},
+		},
+		{
+			input: "Repeat A 1 2 {\n  Pen 1 ]\n}",
+			expected: []string{
+				"test.dbn:2:9: syntax error: unexpected `]` in Pen statement, expected newline or `}`",
+			},
+		},
+		{
+			input: "Repeat A 1 2",
+			expected: []string{
+				"test.dbn:1:13: syntax error: unexpected end of file in Repeat statement, expected `{`",
+			},
+		},
+		{
+			input: "Set <Array 1 2> 3",
+			expected: []string{
+				"test.dbn:1:14: syntax error: unexpected `2` in Set statement",
+			},
+		},
+		{
+			input: "Load a\n",
+			expected: []string{
+				"test.dbn:1:6: syntax error: unexpected `a` in Load statement, expected string",
+			},
+		},
+		{
+			input: "Box 1\n\"a\"",
+			expected: []string{
+				"test.dbn:2:1: syntax error: unexpected `\"a\"`",
+			},
+		},
+		{
+			input: "Box\n\n)",
+			expected: []string{
+				"test.dbn:3:1: syntax error: unexpected `)`",
+			},
+		},
+		{
+			input: "Paper ]\nPen 50\nLine 1 2 3\nSet X",
+			expected: []string{
+				"test.dbn:1:7: syntax error: unexpected `]` in Paper statement, expected expression",
+				"test.dbn:3:11: syntax error: unexpected newline in Line statement",
+				"test.dbn:4:6: syntax error: unexpected end of file in Set statement",
+			},
+		},
+		{
+			input: "Same? 1 1 {\n  Pen ]\n  Line 1\n}\nPaper [",
+			expected: []string{
+				"test.dbn:2:7: syntax error: unexpected `]` in Pen statement, expected expression",
+				"test.dbn:3:9: syntax error: unexpected newline in Line statement",
+				"test.dbn:5:7: syntax error: unexpected `[` in Paper statement, expected expression",
+			},
+		},
+		{
+			input: "Repeat A 1 2 { Pen 1 ] }\nPen ]\n",
+			expected: []string{
+				"test.dbn:1:22: syntax error: unexpected `]` in Pen statement, expected newline or `}`",
+				"test.dbn:2:5: syntax error: unexpected `]` in Pen statement, expected expression",
 			},
 		},
 	}
@@ -240,10 +586,11 @@ func TestErrors(t *testing.T) {
 
 		if len(l.Errors) != len(test.expected) {
 			t.Errorf("test %d: expected %d errors, got %d", i, len(test.expected), len(l.Errors))
+			continue
 		}
 
 		for j, err := range l.Errors {
-			if err != test.expected[j] {
+			if err.String() != test.expected[j] {
 				t.Errorf("test %d: expected %s, got %s", i, test.expected[j], err)
 			}
 		}
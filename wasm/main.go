@@ -10,6 +10,7 @@ import (
 	"syscall/js"
 
 	"github.com/tnantoka/dbngo/evaluator"
+	"github.com/tnantoka/dbngo/parser"
 )
 
 func main() {
@@ -45,7 +46,7 @@ func generatePNG(input string) string {
 	img := e.Eval(strings.NewReader(input), "input")
 
 	if len(e.Errors) > 0 {
-		return strings.Join(e.Errors, "\n")
+		return parser.FormatDiagnostics(e.Errors)
 	}
 
 	buf := &bytes.Buffer{}
@@ -63,11 +64,12 @@ func generateGIF(input string) string {
 	e := evaluator.New()
 	e.WithGIF = true
 	e.MaxFrames = 200
+	e.ForeverFrames = e.MaxFrames
 
 	e.Eval(strings.NewReader(input), "input")
 
 	if len(e.Errors) > 0 {
-		return strings.Join(e.Errors, "\n")
+		return parser.FormatDiagnostics(e.Errors)
 	}
 
 	buf := &bytes.Buffer{}